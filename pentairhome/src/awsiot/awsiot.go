@@ -0,0 +1,280 @@
+// Package awsiot receives Pentair device updates from their AWS IoT shadows,
+// using MQTT over a WebSocket signed with the same Cognito identity
+// credentials as the Pentair cloud API.
+package awsiot
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/eclipse/paho.golang/autopaho"
+	"github.com/eclipse/paho.golang/paho"
+)
+
+// Update carries the fields a device reported in a shadow update.
+type Update struct {
+	DeviceID string
+	Fields   map[string]string
+}
+
+type Config struct {
+	Context     context.Context
+	Endpoint    string
+	Region      string
+	Credentials aws.CredentialsProvider
+	Updates     chan<- Update
+}
+
+// Connection is a single connection to AWS IoT. The signed URL it connects
+// with is only valid while the credentials are, so it does not reconnect;
+// once Done is closed a new Connection has to be made.
+type Connection struct {
+	client *autopaho.ConnectionManager
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu         sync.Mutex
+	subscribed map[string]bool
+}
+
+const (
+	connectTimeout = 30 * time.Second
+	// The signature only has to be valid for the WebSocket handshake
+	presignExpiry = 5 * time.Minute
+)
+
+func Connect(config Config) (*Connection, error) {
+	creds, err := config.Credentials.Retrieve(config.Context)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve credentials: %w", err)
+	}
+
+	u, err := presignURL(config.Context, config.Endpoint, config.Region, creds, time.Now())
+
+	if err != nil {
+		return nil, err
+	}
+
+	clientID, err := newClientID()
+
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(config.Context)
+	connection := &Connection{ctx: ctx, cancel: cancel, subscribed: map[string]bool{}}
+
+	client, err := autopaho.NewConnection(ctx, autopaho.ClientConfig{
+		ServerUrls:                    []*url.URL{u},
+		KeepAlive:                     30,
+		CleanStartOnInitialConnection: true,
+		ConnectTimeout:                connectTimeout,
+		OnConnectionDown:              func() bool { return false },
+		OnConnectError:                func(err error) { log.Printf("error whilst connecting to AWS IoT: %s", err) },
+		ClientConfig: paho.ClientConfig{
+			ClientID: clientID,
+			OnPublishReceived: []func(paho.PublishReceived) (bool, error){
+				func(pr paho.PublishReceived) (bool, error) {
+					update, err := parseShadowUpdate(pr.Packet.Topic, pr.Packet.Payload)
+
+					if err != nil {
+						log.Printf("Ignoring shadow update on %s: %s", pr.Packet.Topic, err)
+						return true, nil
+					}
+
+					select {
+					case config.Updates <- update:
+					case <-ctx.Done():
+					}
+
+					return true, nil
+				},
+			},
+		},
+	})
+
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to create AWS IoT connection: %s", err)
+	}
+
+	connection.client = client
+	awaitCtx, awaitCancel := context.WithTimeout(ctx, connectTimeout)
+	defer awaitCancel()
+
+	if err := client.AwaitConnection(awaitCtx); err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to connect to AWS IoT: %s", err)
+	}
+
+	return connection, nil
+}
+
+// Subscribe starts receiving shadow updates for any of deviceIDs not already
+// subscribed to.
+func (c *Connection) Subscribe(deviceIDs []string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	subscribe := &paho.Subscribe{}
+
+	for _, deviceID := range deviceIDs {
+		if !c.subscribed[deviceID] {
+			subscribe.Subscriptions = append(subscribe.Subscriptions, paho.SubscribeOptions{Topic: shadowTopic(deviceID), QoS: 1})
+		}
+	}
+
+	if len(subscribe.Subscriptions) == 0 {
+		return nil
+	}
+
+	if _, err := c.client.Subscribe(c.ctx, subscribe); err != nil {
+		return fmt.Errorf("failed to subscribe to device shadows: %s", err)
+	}
+
+	for _, deviceID := range deviceIDs {
+		c.subscribed[deviceID] = true
+	}
+
+	return nil
+}
+
+// Done is closed when the connection has been lost or closed.
+func (c *Connection) Done() <-chan struct{} {
+	return c.client.Done()
+}
+
+func (c *Connection) Close() {
+	c.cancel()
+	<-c.client.Done()
+}
+
+func shadowTopic(deviceID string) string {
+	return fmt.Sprintf("$aws/things/%s/shadow/update/documents", deviceID)
+}
+
+// presignURL signs the WebSocket URL with SigV4 in the query string. AWS IoT
+// expects the session token to be added after signing rather than signed.
+func presignURL(ctx context.Context, endpoint, region string, creds aws.Credentials, now time.Time) (*url.URL, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("wss://%s/mqtt", endpoint), nil)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AWS IoT request: %s", err)
+	}
+
+	query := req.URL.Query()
+	query.Set("X-Amz-Expires", fmt.Sprintf("%d", int(presignExpiry.Seconds())))
+	req.URL.RawQuery = query.Encode()
+
+	sessionToken := creds.SessionToken
+	creds.SessionToken = ""
+
+	// SHA-256 of an empty payload
+	const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+	signed, _, err := v4.NewSigner().PresignHTTP(ctx, creds, req, emptyPayloadHash, "iotdevicegateway", region, now)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign AWS IoT request: %s", err)
+	}
+
+	u, err := url.Parse(signed)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signed AWS IoT URL: %s", err)
+	}
+
+	if sessionToken != "" {
+		u.RawQuery += "&X-Amz-Security-Token=" + url.QueryEscape(sessionToken)
+	}
+
+	return u, nil
+}
+
+func newClientID() (string, error) {
+	suffix := make([]byte, 6)
+
+	if _, err := rand.Read(suffix); err != nil {
+		return "", fmt.Errorf("failed to generate client ID: %s", err)
+	}
+
+	return "pentairhome-" + hex.EncodeToString(suffix), nil
+}
+
+type shadowDocuments struct {
+	Current struct {
+		State struct {
+			Reported map[string]json.RawMessage `json:"reported"`
+		} `json:"state"`
+	} `json:"current"`
+}
+
+// parseShadowUpdate reads the reported fields from an update/documents
+// message. Fields are reported either as bare values or as objects with a
+// value, like the fields of the device API.
+func parseShadowUpdate(topic string, payload []byte) (Update, error) {
+	parts := strings.Split(topic, "/")
+
+	if len(parts) != 6 || parts[0] != "$aws" || parts[1] != "things" {
+		return Update{}, fmt.Errorf("unexpected topic")
+	}
+
+	var documents shadowDocuments
+
+	if err := json.Unmarshal(payload, &documents); err != nil {
+		return Update{}, fmt.Errorf("failed to unmarshal shadow: %s", err)
+	}
+
+	update := Update{DeviceID: parts[2], Fields: map[string]string{}}
+
+	for code, raw := range documents.Current.State.Reported {
+		var field struct {
+			Value json.RawMessage `json:"value"`
+		}
+
+		if json.Unmarshal(raw, &field) == nil && field.Value != nil {
+			raw = field.Value
+		}
+
+		if value, ok := scalar(raw); ok {
+			update.Fields[code] = value
+		}
+	}
+
+	return update, nil
+}
+
+func scalar(raw json.RawMessage) (string, bool) {
+	var value any
+
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return "", false
+	}
+
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case float64:
+		return strings.TrimSpace(string(raw)), true
+	case bool:
+		if v {
+			return "1", true
+		}
+
+		return "0", true
+	}
+
+	return "", false
+}
@@ -0,0 +1,57 @@
+package awsiot
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestParseShadowUpdate(t *testing.T) {
+	payload := `{"current": {"state": {"reported": {"ifs3": "512", "ifs4": 2500, "r1": true, "t0": {"value": "78"}, "nested": {"a": 1}}}}}`
+
+	update, err := parseShadowUpdate("$aws/things/abc123/shadow/update/documents", []byte(payload))
+
+	if err != nil {
+		t.Fatalf("parseShadowUpdate() error = %s", err)
+	}
+
+	want := Update{DeviceID: "abc123", Fields: map[string]string{"ifs3": "512", "ifs4": "2500", "r1": "1", "t0": "78"}}
+
+	if !reflect.DeepEqual(update, want) {
+		t.Errorf("parseShadowUpdate() = %+v, want %+v", update, want)
+	}
+
+	if _, err := parseShadowUpdate("pentairhome/abc123", []byte(payload)); err == nil {
+		t.Error("expected an error for an unexpected topic")
+	}
+}
+
+func TestPresignURL(t *testing.T) {
+	creds := aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "SECRET", SessionToken: "token/with+chars"}
+	now := time.Date(2026, time.June, 1, 12, 0, 0, 0, time.UTC)
+
+	u, err := presignURL(context.Background(), "example-ats.iot.us-west-2.amazonaws.com", "us-west-2", creds, now)
+
+	if err != nil {
+		t.Fatalf("presignURL() error = %s", err)
+	}
+
+	if u.Scheme != "wss" || u.Host != "example-ats.iot.us-west-2.amazonaws.com" || u.Path != "/mqtt" {
+		t.Errorf("presignURL() = %s, want wss://example-ats.iot.us-west-2.amazonaws.com/mqtt", u)
+	}
+
+	query := u.Query()
+
+	if query.Get("X-Amz-Signature") == "" || !strings.Contains(query.Get("X-Amz-Credential"), "/us-west-2/iotdevicegateway/") {
+		t.Errorf("presignURL() = %s, want a SigV4 signature for iotdevicegateway", u)
+	}
+
+	// The token is appended after signing, so it must be last and unsigned
+	if query.Get("X-Amz-Security-Token") != creds.SessionToken || !strings.HasSuffix(u.RawQuery, "X-Amz-Security-Token=token%2Fwith%2Bchars") {
+		t.Errorf("presignURL() = %s, want the session token appended", u)
+	}
+}
@@ -0,0 +1,475 @@
+package bridge
+
+import (
+	"context"
+	"log"
+	"pentairhome/devicetypes"
+	"pentairhome/mqtt"
+	"pentairhome/pentaircloud"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/eclipse/paho.golang/paho"
+)
+
+// API is the part of the Pentair cloud client the bridge uses.
+type API interface {
+	ListDevices() ([]pentaircloud.ListDevice, error)
+	GetDevices(deviceIds []string) ([]pentaircloud.Device, error)
+	UpdateDeviceFields(deviceId string, fields map[string]string) error
+	GetSchedule(deviceId string) (*pentaircloud.Schedule, error)
+	UpdateSchedule(deviceId string, schedule pentaircloud.Schedule) error
+	GetProfile() (*pentaircloud.Profile, error)
+}
+
+// Publisher is the part of the MQTT client the bridge publishes with.
+type Publisher interface {
+	PublishDiscovery(topic string, payload []byte) (*paho.PublishResponse, error)
+	PublishState(topic string, payload []byte) (*paho.PublishResponse, error)
+	PublishRetained(topic string, payload []byte) (*paho.PublishResponse, error)
+}
+
+// Bridge mirrors every device on the Pentair account into Home Assistant,
+// adding devices that appear on the account and retiring ones that are removed.
+type Bridge struct {
+	Polling        Polling
+	RelistInterval time.Duration
+	// ExposeUnknownFields publishes fields missing from the field catalogue
+	// as diagnostic sensors
+	ExposeUnknownFields bool
+	// ExperimentalDevices adds devices of families not checked against a
+	// real device
+	ExperimentalDevices bool
+	// ExperimentalControls publishes entities whose commands have not been
+	// checked against a real device as writable rather than read only
+	ExperimentalControls bool
+	// IoTEndpoint is where RunPush receives device updates from
+	IoTEndpoint string
+	// Energy holds the energy totals integrated from power readings
+	Energy *EnergyStore
+	// UnitSystem is the units readings are published in, or empty to follow
+	// the Pentair account
+	UnitSystem devicetypes.UnitSystem
+	// Heartbeat is how often a device's state is published when nothing has
+	// changed; zero publishes every update
+	Heartbeat time.Duration
+	// Deadbands is how far a value of each device class must move to count
+	// as a change
+	Deadbands map[string]float64
+
+	mqttClient     Publisher
+	topics         mqtt.Topics
+	commands       <-chan mqtt.Command
+	statusMessages <-chan string
+	apiClient      API
+
+	// region and credentials sign the AWS IoT connection RunPush opens
+	region      string
+	credentials aws.CredentialsProvider
+
+	changes *changeTracker
+
+	mu        sync.Mutex
+	devices   map[string]*pentaircloud.Device
+	schedules map[string]*pentaircloud.Schedule
+
+	// skipped holds the experimental devices already logged as skipped. It is
+	// only used by RunDiscovery.
+	skipped map[string]bool
+
+	pushConnected atomic.Bool
+	lastCommand   atomic.Int64
+	wake          chan struct{}
+}
+
+func New(mqttClient *mqtt.MQTTWrapper, apiClient *pentaircloud.APIClient) *Bridge {
+	b := newBridge(mqttClient, mqttClient.Topics, apiClient)
+	b.commands = mqttClient.Commands
+	b.statusMessages = mqttClient.StatusMessages
+	b.region = *apiClient.AWSRegion
+	b.credentials = apiClient.CredsCache
+
+	return b
+}
+
+func newBridge(publisher Publisher, topics mqtt.Topics, api API) *Bridge {
+	return &Bridge{
+		Polling:        DefaultPolling(),
+		RelistInterval: 15 * time.Minute,
+		Energy:         LoadEnergyStore(""),
+		UnitSystem:     devicetypes.Imperial,
+		Heartbeat:      15 * time.Minute,
+		changes:        newChangeTracker(),
+		mqttClient:     publisher,
+		topics:         topics,
+		apiClient:      api,
+		devices:        map[string]*pentaircloud.Device{},
+		schedules:      map[string]*pentaircloud.Schedule{},
+		skipped:        map[string]bool{},
+		wake:           make(chan struct{}, 1),
+	}
+}
+
+// RunDiscovery lists the account's devices straight away and then every
+// RelistInterval.
+func (b *Bridge) RunDiscovery(ctx context.Context) error {
+	// Set before any device is known, so nothing publishes in other units
+	if b.UnitSystem == "" {
+		b.UnitSystem = b.accountUnits()
+	}
+
+	ticker := time.NewTicker(b.RelistInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := b.discover(); err != nil {
+			return err
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// accountUnits reads the unit system chosen in the Pentair account from its
+// profile, assuming imperial units when the profile does not say.
+func (b *Bridge) accountUnits() devicetypes.UnitSystem {
+	profile, err := b.apiClient.GetProfile()
+
+	if err != nil {
+		log.Printf("Failed to get profile, publishing imperial units: %s", err)
+		return devicetypes.Imperial
+	}
+
+	units, ok := devicetypes.ParseUnitSystem(profile.Units)
+
+	if !ok {
+		log.Printf("Profile does not give a unit system, publishing imperial units")
+		return devicetypes.Imperial
+	}
+
+	log.Printf("Publishing %s units as chosen in the Pentair account", units)
+
+	return units
+}
+
+// RunPoller fetches and publishes the state of every known device, waiting
+// as long as Polling says between polls, or RelistInterval while push updates
+// are arriving. When the API throttles polling it backs off rather than
+// failing.
+func (b *Bridge) RunPoller(ctx context.Context) error {
+	var wait time.Duration
+	var throttled bool
+
+	for {
+		if !throttled {
+			wait = b.pollWait()
+		}
+
+		timer := time.NewTimer(wait)
+
+		select {
+		case <-timer.C:
+		case <-b.wake:
+			// A command was sent or push dropped, so work out the wait again
+			timer.Stop()
+			throttled = false
+			continue
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+
+		err := b.poll()
+
+		if retryAfter, isThrottled := pentaircloud.IsThrottled(err); isThrottled {
+			wait, throttled = b.Polling.throttled(wait, retryAfter), true
+			log.Printf("Polling is being throttled, next poll in %s", wait)
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		throttled = false
+	}
+}
+
+// units converts readings to the units they are published in.
+func (b *Bridge) units() devicetypes.Units {
+	return devicetypes.Units{Published: b.UnitSystem}
+}
+
+// included reports whether a listed device is added to Home Assistant, logging
+// the first time an experimental one is skipped.
+func (b *Bridge) included(device pentaircloud.ListDevice) bool {
+	family, ok := devicetypes.Lookup(device.ProductInfo.Model)
+
+	if !ok || !family.Experimental || b.ExperimentalDevices {
+		return true
+	}
+
+	if !b.skipped[device.DeviceID] {
+		log.Printf("Skipping %s device %s (%s), support for it is experimental: turn on experimental_devices to add it", device.ProductInfo.Model, device.DeviceID, device.ProductInfo.NickName)
+		b.skipped[device.DeviceID] = true
+	}
+
+	return false
+}
+
+// expireAfter is how long Home Assistant keeps a sensor's state without an
+// update: three heartbeats, or three of the longest waits between polls if
+// that is longer, so a skipped poll or two does not make entities unavailable.
+func (b *Bridge) expireAfter() time.Duration {
+	longest := max(b.Heartbeat, b.Polling.Interval)
+
+	if b.Polling.Adaptive {
+		longest = max(longest, b.Polling.Slow)
+	}
+
+	if b.IoTEndpoint != "" {
+		longest = max(longest, b.RelistInterval)
+	}
+
+	return 3 * longest
+}
+
+func (b *Bridge) pollWait() time.Duration {
+	if b.pushConnected.Load() {
+		return b.RelistInterval
+	}
+
+	return b.Polling.next(time.Now(), b.snapshot(), time.Unix(0, b.lastCommand.Load()))
+}
+
+// commandSent makes the poller poll sooner so the result of a command shows.
+func (b *Bridge) commandSent() {
+	b.lastCommand.Store(time.Now().UnixNano())
+	b.wakePoller()
+}
+
+// wakePoller makes the poller work out how long to wait again, unless it has
+// already been woken.
+func (b *Bridge) wakePoller() {
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+}
+
+// RunStatusListener republishes discovery whenever Home Assistant comes back
+// online, as it forgets entities that were not retained, and makes the next
+// poll publish every device's state again.
+func (b *Bridge) RunStatusListener(ctx context.Context) error {
+	for {
+		select {
+		case statusMessage := <-b.statusMessages:
+			log.Printf("Received status message: %s", statusMessage)
+
+			if statusMessage != "online" {
+				continue
+			}
+
+			log.Println("Home Assistant is online")
+
+			if err := b.republish(); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// republish sends the discovery configs, state and schedule of every known
+// device again, so a restarted Home Assistant shows them straight away rather
+// than once the next change or heartbeat is published.
+func (b *Bridge) republish() error {
+	for _, device := range b.snapshot() {
+		log.Printf("Sending sensor config for device: %s", device.DeviceID)
+
+		if err := b.publishDiscovery(device); err != nil {
+			return err
+		}
+
+		// The last state sent is gone with Home Assistant, so it is sent
+		// whatever the deadbands say
+		b.changes.forget(device.DeviceID)
+
+		if err := b.publishState(device); err != nil {
+			return err
+		}
+
+		if schedule := b.schedule(device.DeviceID); schedule != nil {
+			if err := b.publishSchedule(device, schedule); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (b *Bridge) discover() error {
+	listed, err := b.apiClient.ListDevices()
+
+	if err != nil {
+		return err
+	}
+
+	listedIDs := map[string]bool{}
+	var newIDs []string
+
+	for _, device := range listed {
+		if !b.included(device) {
+			continue
+		}
+
+		listedIDs[device.DeviceID] = true
+
+		if b.device(device.DeviceID) == nil {
+			newIDs = append(newIDs, device.DeviceID)
+		}
+	}
+
+	for _, device := range b.snapshot() {
+		if listedIDs[device.DeviceID] {
+			continue
+		}
+
+		log.Printf("Device %s (%s) is no longer on the account, removing it", device.DeviceID, device.ProductInfo.NickName)
+
+		if err := b.removeDiscovery(device); err != nil {
+			return err
+		}
+
+		b.mu.Lock()
+		delete(b.devices, device.DeviceID)
+		delete(b.schedules, device.DeviceID)
+		b.mu.Unlock()
+
+		b.changes.forget(device.DeviceID)
+	}
+
+	if len(newIDs) > 0 {
+		devices, err := b.apiClient.GetDevices(newIDs)
+
+		if err != nil {
+			return err
+		}
+
+		for i := range devices {
+			device := &devices[i]
+			log.Printf("Found %s device %s (%s)", device.ProductInfo.Model, device.DeviceID, device.ProductInfo.NickName)
+
+			b.store(device)
+
+			if err := b.publishDiscovery(device); err != nil {
+				return err
+			}
+
+			if err := b.publishState(device); err != nil {
+				log.Printf("Failed to publish state for %s: %s", device.DeviceID, err)
+			}
+		}
+	}
+
+	// Schedules rarely change, so they are only fetched with the device list
+	for _, device := range b.snapshot() {
+		if err := b.refreshSchedule(device); err != nil {
+			log.Printf("Failed to refresh schedule for %s: %s", device.DeviceID, err)
+		}
+	}
+
+	return nil
+}
+
+func (b *Bridge) poll() error {
+	known := b.snapshot()
+
+	if len(known) == 0 {
+		return nil
+	}
+
+	ids := make([]string, 0, len(known))
+
+	for _, device := range known {
+		ids = append(ids, device.DeviceID)
+	}
+
+	devices, err := b.apiClient.GetDevices(ids)
+
+	if err != nil {
+		return err
+	}
+
+	for i := range devices {
+		device := &devices[i]
+
+		if !b.storeIfKnown(device) {
+			// Removed by discovery while the request was in flight
+			continue
+		}
+
+		// One device reporting bad data should not stop the others updating
+		if err := b.publishState(device); err != nil {
+			log.Printf("Failed to publish state for %s: %s", device.DeviceID, err)
+		}
+	}
+
+	return nil
+}
+
+func (b *Bridge) device(deviceID string) *pentaircloud.Device {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.devices[deviceID]
+}
+
+func (b *Bridge) store(device *pentaircloud.Device) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.devices[device.DeviceID] = device
+}
+
+// storeIfKnown stores device unless discovery has removed it, reporting
+// whether it did.
+func (b *Bridge) storeIfKnown(device *pentaircloud.Device) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.devices[device.DeviceID]; !ok {
+		return false
+	}
+
+	b.devices[device.DeviceID] = device
+
+	return true
+}
+
+// snapshot returns the known devices ordered by ID.
+func (b *Bridge) snapshot() []*pentaircloud.Device {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	devices := make([]*pentaircloud.Device, 0, len(b.devices))
+
+	for _, device := range b.devices {
+		devices = append(devices, device)
+	}
+
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].DeviceID < devices[j].DeviceID
+	})
+
+	return devices
+}
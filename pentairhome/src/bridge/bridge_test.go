@@ -0,0 +1,326 @@
+package bridge
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"pentairhome/devicetypes"
+	"pentairhome/mqtt"
+	"pentairhome/pentaircloud"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/eclipse/paho.golang/paho"
+)
+
+// fakeAPI serves the devices in listed, reporting fields or a pump's power if
+// it is nil, and records which were fetched and the fields and schedules set
+// on them. Whatever is set is reported from then on. Getting the profile fails
+// if it is nil.
+type fakeAPI struct {
+	listed    []pentaircloud.ListDevice
+	fields    map[string]pentaircloud.DeviceField
+	schedule  pentaircloud.Schedule
+	profile   *pentaircloud.Profile
+	fetched   [][]string
+	updates   []map[string]string
+	schedules []pentaircloud.Schedule
+	// fetching is called once the devices have been fetched
+	fetching func()
+}
+
+func (f *fakeAPI) ListDevices() ([]pentaircloud.ListDevice, error) {
+	return f.listed, nil
+}
+
+func (f *fakeAPI) GetDevices(deviceIds []string) ([]pentaircloud.Device, error) {
+	f.fetched = append(f.fetched, deviceIds)
+	devices := make([]pentaircloud.Device, 0, len(deviceIds))
+	fields := f.fields
+
+	if fields == nil {
+		fields = map[string]pentaircloud.DeviceField{"ifs3": {Value: "500"}}
+	}
+
+	for _, id := range deviceIds {
+		for _, listed := range f.listed {
+			if listed.DeviceID == id {
+				devices = append(devices, pentaircloud.Device{
+					DeviceID:    id,
+					DeviceType:  listed.DeviceType,
+					Online:      true,
+					ProductInfo: listed.ProductInfo,
+					Fields:      maps.Clone(fields),
+				})
+			}
+		}
+	}
+
+	if f.fetching != nil {
+		f.fetching()
+	}
+
+	return devices, nil
+}
+
+func (f *fakeAPI) UpdateDeviceFields(deviceId string, fields map[string]string) error {
+	f.updates = append(f.updates, fields)
+
+	for code, value := range fields {
+		field := f.fields[code]
+		field.Value = value
+		f.fields[code] = field
+	}
+
+	return nil
+}
+
+func (f *fakeAPI) GetSchedule(deviceId string) (*pentaircloud.Schedule, error) {
+	return &pentaircloud.Schedule{Programs: slices.Clone(f.schedule.Programs)}, nil
+}
+
+func (f *fakeAPI) GetProfile() (*pentaircloud.Profile, error) {
+	if f.profile == nil {
+		return nil, errors.New("no profile")
+	}
+
+	return f.profile, nil
+}
+
+func (f *fakeAPI) UpdateSchedule(deviceId string, schedule pentaircloud.Schedule) error {
+	f.schedules = append(f.schedules, schedule)
+	f.schedule = schedule
+	return nil
+}
+
+// fakePublisher records discovery configs by topic, with an empty payload for
+// removed ones, the topics state was published to and the retained topics
+// that were cleared.
+type fakePublisher struct {
+	discovery map[string]string
+	states    []string
+	cleared   []string
+}
+
+func (f *fakePublisher) PublishDiscovery(topic string, payload []byte) (*paho.PublishResponse, error) {
+	f.discovery[topic] = string(payload)
+	return nil, nil
+}
+
+func (f *fakePublisher) PublishState(topic string, payload []byte) (*paho.PublishResponse, error) {
+	f.states = append(f.states, topic)
+	return nil, nil
+}
+
+func (f *fakePublisher) PublishRetained(topic string, payload []byte) (*paho.PublishResponse, error) {
+	if len(payload) == 0 {
+		f.cleared = append(f.cleared, topic)
+	}
+
+	return nil, nil
+}
+
+func TestDiscover(t *testing.T) {
+	pump := pentaircloud.ListDevice{DeviceID: "pump", ProductInfo: pentaircloud.ProductInfo{Model: "IntelliConnect"}}
+	softener := pentaircloud.ListDevice{DeviceID: "softener", ProductInfo: pentaircloud.ProductInfo{Model: "Water Softener"}}
+
+	tests := []struct {
+		name   string
+		before []pentaircloud.ListDevice
+		after  []pentaircloud.ListDevice
+		// fetched lists the devices fetched by the second discovery
+		fetched [][]string
+		added   bool
+		removed bool
+		known   bool
+	}{
+		{"device appears", nil, []pentaircloud.ListDevice{pump}, [][]string{{"pump"}}, true, false, true},
+		{"device disappears", []pentaircloud.ListDevice{pump}, nil, nil, false, true, false},
+		{"relist without changes", []pentaircloud.ListDevice{pump}, []pentaircloud.ListDevice{pump}, nil, false, false, true},
+		{"experimental device", nil, []pentaircloud.ListDevice{softener}, nil, false, false, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			api := &fakeAPI{listed: test.before}
+			publisher := &fakePublisher{discovery: map[string]string{}}
+			b := newBridge(publisher, mqtt.Topics{Base: "pentairhome", DiscoveryPrefix: "homeassistant"}, api)
+
+			if err := b.discover(); err != nil {
+				t.Fatalf("discover() error = %s", err)
+			}
+
+			api.listed, api.fetched = test.after, nil
+			publisher.discovery, publisher.cleared = map[string]string{}, nil
+
+			if err := b.discover(); err != nil {
+				t.Fatalf("discover() error = %s", err)
+			}
+
+			if !reflect.DeepEqual(api.fetched, test.fetched) {
+				t.Errorf("fetched %v, want %v", api.fetched, test.fetched)
+			}
+
+			var added, removed bool
+
+			for topic, payload := range publisher.discovery {
+				if !strings.HasPrefix(topic, "homeassistant/") {
+					t.Errorf("published discovery to %s", topic)
+				}
+
+				if payload == "" {
+					removed = true
+				} else {
+					added = true
+				}
+			}
+
+			if added != test.added || removed != test.removed {
+				t.Errorf("added configs = %t and removed configs = %t, want %t and %t", added, removed, test.added, test.removed)
+			}
+
+			if cleared := len(publisher.cleared) > 0; cleared != test.removed {
+				t.Errorf("cleared retained topics %v, want cleared = %t", publisher.cleared, test.removed)
+			}
+
+			if known := len(b.snapshot()) > 0; known != test.known {
+				t.Errorf("device known = %t, want %t", known, test.known)
+			}
+		})
+	}
+}
+
+func TestPollSkipsRemovedDevice(t *testing.T) {
+	pump := pentaircloud.ListDevice{DeviceID: "pump", ProductInfo: pentaircloud.ProductInfo{Model: "IntelliConnect"}}
+	api := &fakeAPI{listed: []pentaircloud.ListDevice{pump}}
+	b := newBridge(&fakePublisher{discovery: map[string]string{}}, mqtt.Topics{Base: "pentairhome", DiscoveryPrefix: "homeassistant"}, api)
+
+	if err := b.discover(); err != nil {
+		t.Fatalf("discover() error = %s", err)
+	}
+
+	// Discovery removes the device while the poll is fetching it
+	api.fetching = func() {
+		api.listed, api.fetching = nil, nil
+
+		if err := b.discover(); err != nil {
+			t.Errorf("discover() error = %s", err)
+		}
+	}
+
+	if err := b.poll(); err != nil {
+		t.Fatalf("poll() error = %s", err)
+	}
+
+	if devices := b.snapshot(); len(devices) != 0 {
+		t.Errorf("known devices = %d after the poll, want the removed device to stay removed", len(devices))
+	}
+}
+
+func TestExperimentalControls(t *testing.T) {
+	pump := pentaircloud.ListDevice{DeviceID: "pump", ProductInfo: pentaircloud.ProductInfo{Model: "IntelliConnect"}}
+	// The writable and read only forms of the heater and a relay
+	writable := []string{"homeassistant/climate/ph_pump_heater/config", "homeassistant/light/ph_pump_r1/config"}
+	readOnly := []string{"homeassistant/sensor/ph_pump_heater/config", "homeassistant/binary_sensor/ph_pump_r1/config"}
+
+	for _, enabled := range []bool{false, true} {
+		api := &fakeAPI{listed: []pentaircloud.ListDevice{pump}, fields: map[string]pentaircloud.DeviceField{
+			"t0":  {Value: "78"},
+			"s12": {Value: "1"},
+			"s13": {Value: "84"},
+			"r1":  {Name: "Pool Light", Value: "1"},
+		}}
+		publisher := &fakePublisher{discovery: map[string]string{}}
+		b := newBridge(publisher, mqtt.Topics{Base: "pentairhome", DiscoveryPrefix: "homeassistant"}, api)
+		b.ExperimentalControls = enabled
+
+		if err := b.discover(); err != nil {
+			t.Fatalf("discover() error = %s", err)
+		}
+
+		published, cleared := readOnly, writable
+
+		if enabled {
+			published, cleared = writable, readOnly
+		}
+
+		for _, topic := range published {
+			if payload, ok := publisher.discovery[topic]; !ok || payload == "" {
+				t.Errorf("with experimental controls %t, expected a config on %s", enabled, topic)
+			}
+		}
+
+		for _, topic := range cleared {
+			if payload, ok := publisher.discovery[topic]; !ok || payload != "" {
+				t.Errorf("with experimental controls %t, expected %s to be cleared, got %q", enabled, topic, payload)
+			}
+		}
+	}
+}
+
+func TestAccountUnits(t *testing.T) {
+	tests := []struct {
+		name       string
+		profile    *pentaircloud.Profile
+		configured devicetypes.UnitSystem
+		want       devicetypes.UnitSystem
+	}{
+		{"metric account", &pentaircloud.Profile{Units: "°C"}, "", devicetypes.Metric},
+		{"imperial account", &pentaircloud.Profile{Units: "imperial"}, "", devicetypes.Imperial},
+		{"profile without units", &pentaircloud.Profile{}, "", devicetypes.Imperial},
+		{"profile unavailable", nil, "", devicetypes.Imperial},
+		{"configured units", &pentaircloud.Profile{Units: "°C"}, devicetypes.Imperial, devicetypes.Imperial},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := newBridge(&fakePublisher{discovery: map[string]string{}}, mqtt.Topics{Base: "pentairhome", DiscoveryPrefix: "homeassistant"}, &fakeAPI{profile: test.profile})
+			b.UnitSystem = test.configured
+
+			// A cancelled context makes RunDiscovery stop after the first
+			// discovery
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			if err := b.RunDiscovery(ctx); !errors.Is(err, context.Canceled) {
+				t.Fatalf("RunDiscovery() error = %v", err)
+			}
+
+			if b.UnitSystem != test.want {
+				t.Errorf("UnitSystem = %q, want %q", b.UnitSystem, test.want)
+			}
+		})
+	}
+}
+
+func TestRepublish(t *testing.T) {
+	pump := pentaircloud.ListDevice{DeviceID: "pump", ProductInfo: pentaircloud.ProductInfo{Model: "IntelliConnect"}}
+	api := &fakeAPI{listed: []pentaircloud.ListDevice{pump}, schedule: pentaircloud.Schedule{Programs: []pentaircloud.ScheduleProgram{{ID: 1, Name: "Morning"}}}}
+	publisher := &fakePublisher{discovery: map[string]string{}}
+	b := newBridge(publisher, mqtt.Topics{Base: "pentairhome", DiscoveryPrefix: "homeassistant"}, api)
+
+	if err := b.discover(); err != nil {
+		t.Fatalf("discover() error = %s", err)
+	}
+
+	if err := b.poll(); err != nil {
+		t.Fatalf("poll() error = %s", err)
+	}
+
+	// Nothing has changed since the poll, but Home Assistant has lost it
+	publisher.discovery, publisher.states = map[string]string{}, nil
+
+	if err := b.republish(); err != nil {
+		t.Fatalf("republish() error = %s", err)
+	}
+
+	if publisher.discovery["homeassistant/sensor/ph_pump_power/config"] == "" {
+		t.Error("expected the discovery configs to be published again")
+	}
+
+	if want := []string{"pentairhome/pump", "pentairhome/pump/schedule"}; !reflect.DeepEqual(publisher.states, want) {
+		t.Errorf("published state to %v, want %v", publisher.states, want)
+	}
+}
@@ -0,0 +1,85 @@
+package bridge
+
+import (
+	"math"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// published is the last state payload sent for a device.
+type published struct {
+	values map[string]any
+	at     time.Time
+}
+
+// changeTracker remembers what was last published for each device, so state
+// is only sent again when a value moves by more than its deadband or the
+// heartbeat is due.
+type changeTracker struct {
+	mu    sync.Mutex
+	state map[string]published
+}
+
+func newChangeTracker() *changeTracker {
+	return &changeTracker{state: map[string]published{}}
+}
+
+// due reports whether values should be published for the device. deadband
+// gives how far a numeric value may move from the one last published before
+// it counts as a change.
+func (c *changeTracker) due(deviceID string, values map[string]any, now time.Time, heartbeat time.Duration, deadband func(key string) float64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	last, ok := c.state[deviceID]
+
+	return !ok || heartbeat <= 0 || now.Sub(last.at) >= heartbeat || changed(last.values, values, deadband)
+}
+
+// sent records values as published for the device.
+func (c *changeTracker) sent(deviceID string, values map[string]any, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.state[deviceID] = published{values: values, at: now}
+}
+
+// forget makes the device's next state be published whatever it holds.
+func (c *changeTracker) forget(deviceID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.state, deviceID)
+}
+
+func changed(previous, next map[string]any, deadband func(key string) float64) bool {
+	if len(previous) != len(next) {
+		return true
+	}
+
+	for key, value := range next {
+		old, ok := previous[key]
+
+		if !ok {
+			return true
+		}
+
+		oldNumber, oldIsNumber := old.(float64)
+		number, isNumber := value.(float64)
+
+		if oldIsNumber && isNumber {
+			if math.Abs(number-oldNumber) > deadband(key) {
+				return true
+			}
+
+			continue
+		}
+
+		if !reflect.DeepEqual(old, value) {
+			return true
+		}
+	}
+
+	return false
+}
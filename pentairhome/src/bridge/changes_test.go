@@ -0,0 +1,47 @@
+package bridge
+
+import (
+	"testing"
+	"time"
+)
+
+func TestChangeTracker(t *testing.T) {
+	changes := newChangeTracker()
+	start := time.Date(2026, time.June, 1, 12, 0, 0, 0, time.UTC)
+	deadbands := map[string]float64{"power": 1}
+	deadband := func(key string) float64 { return deadbands[key] }
+
+	tests := []struct {
+		name     string
+		values   map[string]any
+		after    time.Duration
+		expected bool
+	}{
+		{"first update", map[string]any{"power": 500.0, "online": true}, 0, true},
+		{"within deadband", map[string]any{"power": 500.5, "online": true}, time.Minute, false},
+		{"outside deadband", map[string]any{"power": 502.0, "online": true}, 2 * time.Minute, true},
+		{"flag changed", map[string]any{"power": 502.0, "online": false}, 3 * time.Minute, true},
+		{"unchanged", map[string]any{"power": 502.0, "online": false}, 4 * time.Minute, false},
+		{"heartbeat", map[string]any{"power": 502.0, "online": false}, 20 * time.Minute, true},
+		{"new value", map[string]any{"power": 502.0, "online": false, "rssi": -60.0}, 21 * time.Minute, true},
+	}
+
+	for _, test := range tests {
+		now := start.Add(test.after)
+		due := changes.due("pump", test.values, now, 15*time.Minute, deadband)
+
+		if due != test.expected {
+			t.Errorf("%s: due = %t, want %t", test.name, due, test.expected)
+		}
+
+		if due {
+			changes.sent("pump", test.values, now)
+		}
+	}
+
+	changes.forget("pump")
+
+	if !changes.due("pump", map[string]any{"power": 502.0, "online": false, "rssi": -60.0}, start.Add(22*time.Minute), 15*time.Minute, deadband) {
+		t.Errorf("expected a forgotten device to be due")
+	}
+}
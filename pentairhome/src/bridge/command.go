@@ -0,0 +1,97 @@
+package bridge
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"pentairhome/devicetypes"
+	"pentairhome/mqtt"
+)
+
+// RunCommandListener applies the commands Home Assistant sends to writable
+// entities. A rejected command is logged rather than stopping the listener, as
+// it is usually a bad value from an automation.
+func (b *Bridge) RunCommandListener(ctx context.Context) error {
+	for {
+		select {
+		case command := <-b.commands:
+			if err := b.handleCommand(command); err != nil {
+				log.Printf("Command on %s failed: %s", command.Topic, err)
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (b *Bridge) handleCommand(command mqtt.Command) error {
+	deviceID, key, setting, ok := b.topics.ParseCommand(command.Topic)
+
+	if !ok {
+		return fmt.Errorf("unexpected command topic")
+	}
+
+	device := b.device(deviceID)
+
+	if device == nil {
+		return fmt.Errorf("unknown device %s", deviceID)
+	}
+
+	if key == "schedule" {
+		return b.handleScheduleCommand(device, setting, command)
+	}
+
+	entity, ok := findEntity(b.entities(device), key)
+
+	if !ok {
+		return fmt.Errorf("device %s has no entity %s", deviceID, key)
+	}
+
+	fields, err := entity.ParseCommand(device, setting, b.units().Command(entity, command.Payload))
+
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Setting %s on %s: %v", entity.Name, deviceID, fields)
+
+	if err := b.apiClient.UpdateDeviceFields(deviceID, fields); err != nil {
+		return err
+	}
+
+	b.commandSent()
+
+	return b.refresh(deviceID)
+}
+
+// refresh fetches and publishes one device straight away, so Home Assistant
+// sees the result of a command without waiting for the next poll.
+func (b *Bridge) refresh(deviceID string) error {
+	devices, err := b.apiClient.GetDevices([]string{deviceID})
+
+	if err != nil {
+		return err
+	}
+
+	for i := range devices {
+		if !b.storeIfKnown(&devices[i]) {
+			continue
+		}
+
+		if err := b.publishState(&devices[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func findEntity(entities []devicetypes.Entity, key string) (devicetypes.Entity, bool) {
+	for _, entity := range entities {
+		if entity.Key == key {
+			return entity, true
+		}
+	}
+
+	return devicetypes.Entity{}, false
+}
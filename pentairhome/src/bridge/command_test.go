@@ -0,0 +1,90 @@
+package bridge
+
+import (
+	"pentairhome/devicetypes"
+	"pentairhome/mqtt"
+	"pentairhome/pentaircloud"
+	"reflect"
+	"testing"
+)
+
+func TestHandleCommand(t *testing.T) {
+	pump := pentaircloud.ListDevice{DeviceID: "pump", ProductInfo: pentaircloud.ProductInfo{Model: "IntelliConnect"}}
+
+	tests := []struct {
+		name     string
+		topic    string
+		payload  string
+		controls bool
+		units    devicetypes.UnitSystem
+		// want is the fields sent to Pentair, or nil if the command must be
+		// rejected without sending anything
+		want map[string]string
+	}{
+		{"pump speed", "pentairhome/pump/targetspeed/set", "2500", true, devicetypes.Imperial, map[string]string{"ifs5": "2500"}},
+		{"pump speed outside the reported range", "pentairhome/pump/targetspeed/set", "3200", true, devicetypes.Imperial, nil},
+		{"pump speed without experimental controls", "pentairhome/pump/targetspeed/set", "2500", false, devicetypes.Imperial, nil},
+		{"heater set point in celsius", "pentairhome/pump/heater/set", "28", true, devicetypes.Metric, map[string]string{"s13": "82"}},
+		{"heater off", "pentairhome/pump/heater/mode/set", "off", true, devicetypes.Imperial, map[string]string{"s12": "0"}},
+		{"heat source", "pentairhome/pump/heater/preset/set", "Solar Only", true, devicetypes.Imperial, map[string]string{"s12": "3"}},
+		{"relay", "pentairhome/pump/r1/set", "ON", true, devicetypes.Imperial, map[string]string{"r1": "1"}},
+		{"read only sensor", "pentairhome/pump/power/set", "100", true, devicetypes.Imperial, nil},
+		{"unknown entity", "pentairhome/pump/missing/set", "1", true, devicetypes.Imperial, nil},
+		{"unknown device", "pentairhome/other/targetspeed/set", "2500", true, devicetypes.Imperial, nil},
+		{"unexpected topic", "elsewhere/pump/targetspeed/set", "2500", true, devicetypes.Imperial, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			api := &fakeAPI{listed: []pentaircloud.ListDevice{pump}, fields: map[string]pentaircloud.DeviceField{
+				"ifs3": {Value: "500"},
+				"ifs5": {Value: "2000", Min: "1000", Max: "3000"},
+				"t0":   {Value: "78"},
+				"s12":  {Value: "1"},
+				"s13":  {Value: "84"},
+				"r1":   {Name: "Waterfall", Value: "0"},
+			}}
+			publisher := &fakePublisher{discovery: map[string]string{}}
+			b := newBridge(publisher, mqtt.Topics{Base: "pentairhome", DiscoveryPrefix: "homeassistant"}, api)
+			b.ExperimentalControls = test.controls
+			b.UnitSystem = test.units
+
+			if err := b.discover(); err != nil {
+				t.Fatalf("discover() error = %s", err)
+			}
+
+			api.fetched, publisher.states = nil, nil
+
+			err := b.handleCommand(mqtt.Command{Topic: test.topic, Payload: test.payload})
+
+			if test.want == nil {
+				if err == nil {
+					t.Error("handleCommand() expected an error")
+				}
+
+				if len(api.updates) != 0 || b.lastCommand.Load() != 0 {
+					t.Errorf("sent %v for a rejected command", api.updates)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("handleCommand() error = %s", err)
+			}
+
+			if !reflect.DeepEqual(api.updates, []map[string]string{test.want}) {
+				t.Errorf("sent %v, want %v", api.updates, test.want)
+			}
+
+			if b.lastCommand.Load() == 0 {
+				t.Error("expected the command to make the poller poll sooner")
+			}
+
+			// The device is fetched again so the result shows straight away
+			if !reflect.DeepEqual(api.fetched, [][]string{{"pump"}}) || len(publisher.states) != 1 {
+				t.Errorf("fetched %v and published state %d times after the command, want the pump once", api.fetched, len(publisher.states))
+			}
+		})
+	}
+}
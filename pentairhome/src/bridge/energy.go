@@ -0,0 +1,110 @@
+package bridge
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"pentairhome/devicetypes"
+	"pentairhome/persist"
+	"sync"
+	"time"
+)
+
+const (
+	// Readings further apart than this leave a gap rather than assuming the
+	// power stayed the same, for example while the add-on was stopped.
+	energyMaxGap = time.Hour
+	// Totals are written at most this often to spare the storage.
+	energySaveInterval = 5 * time.Minute
+)
+
+type energyMeter struct {
+	TotalKWh float64   `json:"total_kwh"`
+	PowerW   float64   `json:"power_w"`
+	Updated  time.Time `json:"updated"`
+}
+
+// EnergyStore integrates each device's power readings into a cumulative
+// energy total and saves the totals so they survive restarts. An empty path
+// disables saving.
+type EnergyStore struct {
+	path string
+
+	mu     sync.Mutex
+	meters map[string]*energyMeter
+	saved  time.Time
+}
+
+// LoadEnergyStore reads the totals saved at path. A missing or unreadable file
+// starts every device from zero.
+func LoadEnergyStore(path string) *EnergyStore {
+	store := &EnergyStore{path: path, meters: map[string]*energyMeter{}}
+
+	if path == "" {
+		return store
+	}
+
+	if err := persist.ReadJSON(path, &store.meters); err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("Ignoring saved energy totals: %s", err)
+		}
+
+		store.meters = map[string]*energyMeter{}
+	}
+
+	// The add-on was stopped for an unknown part of the time since the last
+	// reading, so the next one starts a fresh interval
+	for _, meter := range store.meters {
+		meter.Updated = time.Time{}
+	}
+
+	return store
+}
+
+// Add records a power reading in watts and returns the device's total in
+// kWh. The previous reading is taken to have held until now, which matches
+// devices that only report changes. An offline device's reading is stale, so
+// it is taken to use nothing until it is back online.
+func (s *EnergyStore) Add(deviceID string, powerW float64, online bool, now time.Time) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	meter, ok := s.meters[deviceID]
+
+	if !ok {
+		meter = &energyMeter{}
+		s.meters[deviceID] = meter
+	}
+
+	if !online {
+		powerW = 0
+	}
+
+	if elapsed := now.Sub(meter.Updated); ok && elapsed > 0 && elapsed <= energyMaxGap {
+		meter.TotalKWh += meter.PowerW * elapsed.Hours() / 1000
+	}
+
+	meter.PowerW = max(powerW, 0)
+	meter.Updated = now
+
+	if s.path != "" && now.Sub(s.saved) >= energySaveInterval {
+		if err := persist.WriteJSON(s.path, s.meters); err != nil {
+			log.Printf("Failed to save energy totals: %s", err)
+		} else {
+			s.saved = now
+		}
+	}
+
+	return meter.TotalKWh
+}
+
+// energySource returns the power sensor an energy total is integrated from.
+func energySource(entities []devicetypes.Entity) (devicetypes.Entity, bool) {
+	for _, entity := range entities {
+		if entity.Component == devicetypes.Sensor && entity.DeviceClass == "power" && entity.Unit == "W" {
+			return entity, true
+		}
+	}
+
+	return devicetypes.Entity{}, false
+}
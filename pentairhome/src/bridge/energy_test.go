@@ -0,0 +1,55 @@
+package bridge
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEnergyStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "energy.json")
+	store := LoadEnergyStore(path)
+	start := time.Date(2026, time.June, 1, 12, 0, 0, 0, time.UTC)
+
+	if total := store.Add("pump", 1000, true, start); total != 0 {
+		t.Errorf("first reading total = %f, want 0", total)
+	}
+
+	// 1000 W for 30 minutes, then 500 W for 30 minutes
+	store.Add("pump", 500, true, start.Add(30*time.Minute))
+	total := store.Add("pump", 0, true, start.Add(time.Hour))
+
+	if math.Abs(total-0.75) > 1e-9 {
+		t.Errorf("total = %f, want 0.75", total)
+	}
+
+	// A gap longer than energyMaxGap adds nothing
+	store.Add("pump", 2000, true, start.Add(2*time.Hour))
+	total = store.Add("pump", 2000, true, start.Add(4*time.Hour))
+
+	if math.Abs(total-0.75) > 1e-9 {
+		t.Errorf("total after gap = %f, want 0.75", total)
+	}
+
+	// The last reading of an offline device is not counted while it is
+	// offline
+	store.Add("pump", 2000, false, start.Add(4*time.Hour+30*time.Minute))
+	total = store.Add("pump", 2000, true, start.Add(5*time.Hour))
+
+	if math.Abs(total-1.75) > 1e-9 {
+		t.Errorf("total after going offline = %f, want 1.75", total)
+	}
+
+	// A restart within energyMaxGap does not count the time the add-on was
+	// stopped
+	reloaded := LoadEnergyStore(path)
+
+	if total := reloaded.Add("pump", 1000, true, start.Add(5*time.Hour+6*time.Minute)); math.Abs(total-1.75) > 1e-9 {
+		t.Errorf("total after reload = %f, want 1.75", total)
+	}
+
+	if total := reloaded.Add("pump", 0, true, start.Add(5*time.Hour+12*time.Minute)); math.Abs(total-1.85) > 1e-9 {
+		t.Errorf("total after the first reading since the reload = %f, want 1.85", total)
+	}
+}
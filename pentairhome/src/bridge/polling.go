@@ -0,0 +1,81 @@
+package bridge
+
+import (
+	"pentairhome/devicetypes"
+	"pentairhome/pentaircloud"
+	"time"
+)
+
+// Polling decides how long the poller waits between polls. With Adaptive set
+// it polls every Fast after a command or while a device is active, and every
+// Slow while every device is offline or idle during quiet hours, which run
+// from QuietStart to QuietEnd o'clock local time.
+type Polling struct {
+	Interval   time.Duration
+	Fast       time.Duration
+	Slow       time.Duration
+	Adaptive   bool
+	QuietStart int
+	QuietEnd   int
+}
+
+// How long polling stays fast after a command, so the result shows quickly.
+const commandFollowUp = 2 * time.Minute
+
+func DefaultPolling() Polling {
+	return Polling{
+		Interval:   60 * time.Second,
+		Fast:       15 * time.Second,
+		Slow:       5 * time.Minute,
+		QuietStart: 22,
+		QuietEnd:   6,
+	}
+}
+
+// next returns the wait before the next poll given the devices' last known
+// state and when the last command was sent.
+func (p Polling) next(now time.Time, devices []*pentaircloud.Device, lastCommand time.Time) time.Duration {
+	if !p.Adaptive {
+		return p.Interval
+	}
+
+	if now.Sub(lastCommand) < commandFollowUp {
+		return p.Fast
+	}
+
+	online := false
+
+	for _, device := range devices {
+		if !device.Online {
+			continue
+		}
+
+		online = true
+
+		if devicetypes.Active(device) {
+			return p.Fast
+		}
+	}
+
+	if (len(devices) > 0 && !online) || p.quiet(now) {
+		return p.Slow
+	}
+
+	return p.Interval
+}
+
+func (p Polling) quiet(now time.Time) bool {
+	hour := now.Hour()
+
+	if p.QuietStart <= p.QuietEnd {
+		return hour >= p.QuietStart && hour < p.QuietEnd
+	}
+
+	return hour >= p.QuietStart || hour < p.QuietEnd
+}
+
+// throttled returns the wait after the API throttled a poll: at least what it
+// asked for, and otherwise double the previous wait up to Slow.
+func (p Polling) throttled(previous, retryAfter time.Duration) time.Duration {
+	return max(retryAfter, min(2*previous, p.Slow))
+}
@@ -0,0 +1,70 @@
+package bridge
+
+import (
+	"pentairhome/pentaircloud"
+	"testing"
+	"time"
+)
+
+func TestPollingNext(t *testing.T) {
+	polling := DefaultPolling()
+	polling.Adaptive = true
+
+	day := time.Date(2026, time.June, 1, 14, 0, 0, 0, time.Local)
+	night := time.Date(2026, time.June, 1, 23, 0, 0, 0, time.Local)
+
+	running := &pentaircloud.Device{
+		Online:      true,
+		ProductInfo: pentaircloud.ProductInfo{Model: "IntelliConnect"},
+		Fields:      map[string]pentaircloud.DeviceField{"ifs3": {Value: "850"}},
+	}
+	idle := &pentaircloud.Device{
+		Online:      true,
+		ProductInfo: pentaircloud.ProductInfo{Model: "IntelliConnect"},
+		Fields:      map[string]pentaircloud.DeviceField{"ifs3": {Value: "0"}},
+	}
+	offline := &pentaircloud.Device{ProductInfo: pentaircloud.ProductInfo{Model: "IntelliConnect"}}
+
+	tests := []struct {
+		name        string
+		now         time.Time
+		devices     []*pentaircloud.Device
+		lastCommand time.Time
+		want        time.Duration
+	}{
+		{"idle by day", day, []*pentaircloud.Device{idle}, time.Time{}, polling.Interval},
+		{"idle at night", night, []*pentaircloud.Device{idle}, time.Time{}, polling.Slow},
+		{"running at night", night, []*pentaircloud.Device{idle, running}, time.Time{}, polling.Fast},
+		{"offline", day, []*pentaircloud.Device{offline}, time.Time{}, polling.Slow},
+		{"after command", night, []*pentaircloud.Device{offline}, night.Add(-time.Minute), polling.Fast},
+		{"long after command", day, []*pentaircloud.Device{idle}, day.Add(-time.Hour), polling.Interval},
+	}
+
+	for _, test := range tests {
+		if got := polling.next(test.now, test.devices, test.lastCommand); got != test.want {
+			t.Errorf("%s: next() = %s, want %s", test.name, got, test.want)
+		}
+	}
+
+	polling.Adaptive = false
+
+	if got := polling.next(night, []*pentaircloud.Device{running}, time.Time{}); got != polling.Interval {
+		t.Errorf("next() without adaptive polling = %s, want %s", got, polling.Interval)
+	}
+}
+
+func TestPollingThrottled(t *testing.T) {
+	polling := DefaultPolling()
+
+	if got := polling.throttled(time.Minute, 0); got != 2*time.Minute {
+		t.Errorf("throttled() = %s, want 2m", got)
+	}
+
+	if got := polling.throttled(4*time.Minute, 0); got != polling.Slow {
+		t.Errorf("throttled() = %s, want %s", got, polling.Slow)
+	}
+
+	if got := polling.throttled(time.Minute, 10*time.Minute); got != 10*time.Minute {
+		t.Errorf("throttled() = %s, want 10m", got)
+	}
+}
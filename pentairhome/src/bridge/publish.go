@@ -0,0 +1,268 @@
+package bridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"pentairhome/devicetypes"
+	"pentairhome/pentaircloud"
+	"pentairhome/sensor"
+	"strings"
+	"time"
+)
+
+type entityConfig struct {
+	Component devicetypes.Component
+	UniqueID  string
+	Config    any
+}
+
+// entityConfigs returns the discovery configs for the entities device
+// reports and its schedule programs.
+func (b *Bridge) entityConfigs(device *pentaircloud.Device) []entityConfig {
+	var configs []entityConfig
+
+	topics := b.topics
+	entities := b.entities(device)
+
+	for _, entity := range entities {
+		var config any
+		var base *sensor.SensorConfig
+
+		switch entity.Component {
+		case devicetypes.BinarySensor:
+			binarySensor := sensor.GenerateBinarySensorConfig(topics, device, entity.Name, entity.Key, entity.DeviceClass)
+			config, base = &binarySensor, &binarySensor
+		case devicetypes.Number:
+			minimum, maximum := b.units().Bounds(entity, device)
+			number := sensor.GenerateNumberConfig(topics, device, entity.Name, entity.Key, b.units().Unit(entity), minimum, maximum, entity.Step)
+			config, base = &number, &number.SensorConfig
+		case devicetypes.Climate:
+			minimum, maximum := b.units().Bounds(entity, device)
+			climate := sensor.GenerateClimateConfig(topics, device, entity.Name, entity.Key, temperatureUnit(b.units().Unit(entity)), entity.Heater.PresetNames(), minimum, maximum, entity.Step)
+			config = &climate
+		case devicetypes.Switch:
+			relay := sensor.GenerateSwitchConfig(topics, device, entity.Name, entity.Key)
+			config, base = &relay, &relay.SensorConfig
+		case devicetypes.Light:
+			light := sensor.GenerateLightConfig(topics, device, entity.Name, entity.Key)
+			config = &light
+		default:
+			plain := sensor.GenerateSensorConfig(topics, device, entity.Name, entity.Key, entity.DeviceClass, b.units().Unit(entity))
+			plain.StateClass = entity.NumericStateClass()
+			config, base = &plain, &plain
+		}
+
+		if entity.Diagnostic && base != nil {
+			base.EntityCategory = "diagnostic"
+		}
+
+		if entity.Status != nil && base != nil {
+			base.Availability = sensor.BridgeAvailability(topics)
+		}
+
+		if entity.Component == devicetypes.Sensor || entity.Component == devicetypes.BinarySensor {
+			base.ExpireAfter = int(b.expireAfter().Seconds())
+		}
+
+		configs = append(configs, entityConfig{Component: entity.Component, UniqueID: sensor.UniqueID(device, entity.Key), Config: config})
+	}
+
+	if source, ok := energySource(entities); ok {
+		energy := sensor.GenerateSensorConfig(topics, device, energyName(source), energyKey, "energy", "kWh")
+		energy.StateClass = "total_increasing"
+		energy.ExpireAfter = int(b.expireAfter().Seconds())
+		configs = append(configs, entityConfig{Component: devicetypes.Sensor, UniqueID: energy.UniqueID, Config: &energy})
+	}
+
+	return append(configs, b.scheduleConfigs(device)...)
+}
+
+// entities returns the entities device reports, read only unless
+// experimental controls are turned on.
+func (b *Bridge) entities(device *pentaircloud.Device) []devicetypes.Entity {
+	entities := devicetypes.Entities(device, b.ExposeUnknownFields)
+
+	if !b.ExperimentalControls {
+		for i, entity := range entities {
+			entities[i] = entity.ReadOnly()
+		}
+	}
+
+	return entities
+}
+
+// otherFormTopics returns the discovery topics writable entities have with
+// experimental controls switched the other way, so that switching them does
+// not leave the old entities behind in Home Assistant.
+func (b *Bridge) otherFormTopics(device *pentaircloud.Device) []string {
+	var topics []string
+
+	for _, entity := range devicetypes.Entities(device, b.ExposeUnknownFields) {
+		other := entity.ReadOnly().Component
+
+		if other == entity.Component {
+			continue
+		}
+
+		if !b.ExperimentalControls {
+			other = entity.Component
+		}
+
+		topics = append(topics, b.topics.Discovery(string(other), sensor.UniqueID(device, entity.Key)))
+	}
+
+	return topics
+}
+
+// energyKey names the energy total integrated from a device's power sensor.
+const energyKey = "energy"
+
+// energyName turns "Pump Power" into "Pump Energy".
+func energyName(source devicetypes.Entity) string {
+	return strings.TrimSuffix(source.Name, " Power") + " Energy"
+}
+
+// temperatureUnit converts a unit of measurement to the C or F a climate
+// entity expects.
+func temperatureUnit(unit string) string {
+	if unit == "°C" {
+		return "C"
+	}
+
+	return "F"
+}
+
+func (b *Bridge) configTopic(config entityConfig) string {
+	return b.topics.Discovery(string(config.Component), config.UniqueID)
+}
+
+func (b *Bridge) publishDiscovery(device *pentaircloud.Device) error {
+	configs := b.entityConfigs(device)
+
+	if _, ok := devicetypes.ForDevice(device); !ok {
+		log.Printf("%s devices are not supported, %s will only have status and catalogued fields in Home Assistant", device.ProductInfo.Model, device.DeviceID)
+	}
+
+	for _, config := range configs {
+		message, err := json.Marshal(config.Config)
+		if err != nil {
+			return fmt.Errorf("failed to marshal %s config: %s", config.Component, err)
+		}
+
+		topic := b.configTopic(config)
+		if _, err = b.mqttClient.PublishDiscovery(topic, message); err != nil {
+			return err
+		}
+
+		log.Printf("Published %s config to %s", config.Component, topic)
+	}
+
+	for _, topic := range b.otherFormTopics(device) {
+		if _, err := b.mqttClient.PublishDiscovery(topic, []byte{}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// removeDiscovery publishes an empty config for each entity, which makes Home
+// Assistant delete it, and clears the retained availability and any retained
+// state.
+func (b *Bridge) removeDiscovery(device *pentaircloud.Device) error {
+	for _, config := range b.entityConfigs(device) {
+		if _, err := b.mqttClient.PublishDiscovery(b.configTopic(config), []byte{}); err != nil {
+			return err
+		}
+	}
+
+	topics := b.topics
+
+	for _, topic := range []string{sensor.DeviceAvailabilityTopic(topics, device), topics.Device(device.DeviceID), sensor.ScheduleTopic(topics, device)} {
+		if _, err := b.mqttClient.PublishRetained(topic, []byte{}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (b *Bridge) publishState(device *pentaircloud.Device) error {
+	entities := b.entities(device)
+
+	// Availability goes first so an offline device is marked as such even if
+	// its last readings cannot be parsed
+	if err := b.publishAvailability(device); err != nil {
+		return err
+	}
+
+	state := map[string]any{"deb_off_time": device.DebOffTime}
+
+	for _, entity := range entities {
+		value, ok, err := entity.Value(device)
+
+		if err != nil {
+			return err
+		}
+
+		if ok {
+			state[entity.Key] = b.units().Value(entity, value)
+		}
+	}
+
+	now := time.Now()
+
+	if source, ok := energySource(entities); ok {
+		if power, ok := state[source.Key].(float64); ok {
+			state[energyKey] = math.Round(b.Energy.Add(device.DeviceID, power, device.Online, now)*1000) / 1000
+		}
+	}
+
+	if !b.changes.due(device.DeviceID, state, now, b.Heartbeat, b.deadband(entities)) {
+		return nil
+	}
+
+	stateJSON, err := json.Marshal(state)
+
+	if err != nil {
+		return fmt.Errorf("failed to marshal sensor data: %s", err)
+	}
+
+	topic := b.topics.Device(device.DeviceID)
+
+	if _, err = b.mqttClient.PublishState(topic, stateJSON); err != nil {
+		return err
+	}
+
+	b.changes.sent(device.DeviceID, state, now)
+
+	return nil
+}
+
+// deadband looks up the deadband of each value in a state payload from the
+// device class of the entity it belongs to.
+func (b *Bridge) deadband(entities []devicetypes.Entity) func(key string) float64 {
+	classes := map[string]string{energyKey: "energy"}
+
+	for _, entity := range entities {
+		classes[entity.Key] = entity.DeviceClass
+	}
+
+	return func(key string) float64 {
+		return b.Deadbands[classes[key]]
+	}
+}
+
+func (b *Bridge) publishAvailability(device *pentaircloud.Device) error {
+	availability := "offline"
+
+	if device.Online {
+		availability = "online"
+	}
+
+	_, err := b.mqttClient.PublishRetained(sensor.DeviceAvailabilityTopic(b.topics, device), []byte(availability))
+
+	return err
+}
@@ -0,0 +1,101 @@
+package bridge
+
+import (
+	"encoding/json"
+	"pentairhome/mqtt"
+	"pentairhome/pentaircloud"
+	"reflect"
+	"testing"
+)
+
+func TestDiscoveryAvailability(t *testing.T) {
+	pump := pentaircloud.ListDevice{DeviceID: "pump", ProductInfo: pentaircloud.ProductInfo{Model: "IntelliConnect"}}
+	api := &fakeAPI{
+		listed: []pentaircloud.ListDevice{pump},
+		fields: map[string]pentaircloud.DeviceField{
+			"ifs3": {Value: "500"},
+			"ifs5": {Value: "2000"},
+			"t0":   {Value: "78"},
+			"s12":  {Value: "1"},
+			"s13":  {Value: "84"},
+			"r1":   {Name: "Pool Light", Value: "1"},
+			"r2":   {Name: "Waterfall", Value: "0"},
+		},
+		schedule: pentaircloud.Schedule{Programs: []pentaircloud.ScheduleProgram{{ID: 1, Name: "Morning"}}},
+	}
+	publisher := &fakePublisher{discovery: map[string]string{}}
+	b := newBridge(publisher, mqtt.Topics{Base: "pentairhome", DiscoveryPrefix: "homeassistant"}, api)
+	b.ExperimentalControls = true
+
+	if err := b.discover(); err != nil {
+		t.Fatalf("discover() error = %s", err)
+	}
+
+	// Status entities stay available while their device is offline, so they
+	// can show it is offline
+	status := map[string]bool{
+		"homeassistant/binary_sensor/ph_pump_online/config":       true,
+		"homeassistant/binary_sensor/ph_pump_alarm/config":        true,
+		"homeassistant/binary_sensor/ph_pump_deb_off_stat/config": true,
+	}
+
+	bridgeOnly := []string{"pentairhome/status"}
+	withDevice := []string{"pentairhome/status", "pentairhome/pump/availability"}
+	checked := map[string]bool{}
+
+	for topic, payload := range publisher.discovery {
+		if payload == "" {
+			continue
+		}
+
+		var config struct {
+			Availability []struct {
+				Topic string `json:"topic"`
+			} `json:"availability"`
+			AvailabilityMode string `json:"availability_mode"`
+		}
+
+		if err := json.Unmarshal([]byte(payload), &config); err != nil {
+			t.Fatalf("%s config is not JSON: %s", topic, err)
+		}
+
+		var availability []string
+
+		for _, a := range config.Availability {
+			availability = append(availability, a.Topic)
+		}
+
+		want := withDevice
+
+		if status[topic] {
+			want = bridgeOnly
+		}
+
+		if !reflect.DeepEqual(availability, want) {
+			t.Errorf("%s availability = %v, want %v", topic, availability, want)
+		}
+
+		// Entities must be unavailable when either the add-on or the device
+		// is offline
+		if config.AvailabilityMode != "all" {
+			t.Errorf("%s availability_mode = %q, want all", topic, config.AvailabilityMode)
+		}
+
+		checked[topic] = true
+	}
+
+	for _, topic := range []string{
+		"homeassistant/binary_sensor/ph_pump_online/config",
+		"homeassistant/sensor/ph_pump_power/config",
+		"homeassistant/number/ph_pump_targetspeed/config",
+		"homeassistant/climate/ph_pump_heater/config",
+		"homeassistant/light/ph_pump_r1/config",
+		"homeassistant/switch/ph_pump_r2/config",
+		"homeassistant/switch/ph_pump_program1/config",
+		"homeassistant/sensor/ph_pump_energy/config",
+	} {
+		if !checked[topic] {
+			t.Errorf("expected a config on %s", topic)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package bridge
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"pentairhome/awsiot"
+	"time"
+)
+
+// How often a push connection checks for devices it is not yet subscribed to.
+const pushSubscribeInterval = time.Minute
+
+// RunPush receives device updates from AWS IoT shadows and publishes them as
+// they arrive. While it is connected the poller only runs every
+// RelistInterval; when the connection drops RunPush returns an error so it is
+// restarted, and polling takes over in the meantime.
+func (b *Bridge) RunPush(ctx context.Context) error {
+	updates := make(chan awsiot.Update, 16)
+
+	connection, err := awsiot.Connect(awsiot.Config{
+		Context:     ctx,
+		Endpoint:    b.IoTEndpoint,
+		Region:      b.region,
+		Credentials: b.credentials,
+		Updates:     updates,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	defer connection.Close()
+
+	b.pushConnected.Store(true)
+
+	// The poller may be waiting out RelistInterval, so wake it to go back to
+	// its normal interval
+	defer func() {
+		b.pushConnected.Store(false)
+		b.wakePoller()
+	}()
+
+	log.Printf("Receiving device updates from %s", b.IoTEndpoint)
+
+	ticker := time.NewTicker(pushSubscribeInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := connection.Subscribe(b.deviceIDs()); err != nil {
+			return err
+		}
+
+		select {
+		case update := <-updates:
+			b.applyUpdate(update)
+		case <-ticker.C:
+		case <-connection.Done():
+			return fmt.Errorf("lost connection to %s", b.IoTEndpoint)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// applyUpdate merges the fields from a shadow update into the device's last
+// known state and publishes it.
+func (b *Bridge) applyUpdate(update awsiot.Update) {
+	device := b.device(update.DeviceID)
+
+	if device == nil || len(update.Fields) == 0 {
+		return
+	}
+
+	// Stored devices are shared with the other workers, so update a copy
+	updated := *device
+	updated.Fields = maps.Clone(device.Fields)
+
+	for code, value := range update.Fields {
+		field := updated.Fields[code]
+		field.Value = value
+		updated.Fields[code] = field
+	}
+
+	if !b.storeIfKnown(&updated) {
+		return
+	}
+
+	if err := b.publishState(&updated); err != nil {
+		log.Printf("Failed to publish state for %s: %s", updated.DeviceID, err)
+	}
+}
+
+func (b *Bridge) deviceIDs() []string {
+	devices := b.snapshot()
+	ids := make([]string, 0, len(devices))
+
+	for _, device := range devices {
+		ids = append(ids, device.DeviceID)
+	}
+
+	return ids
+}
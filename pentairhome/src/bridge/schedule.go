@@ -0,0 +1,198 @@
+package bridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"pentairhome/devicetypes"
+	"pentairhome/mqtt"
+	"pentairhome/pentaircloud"
+	"pentairhome/sensor"
+	"strconv"
+	"strings"
+)
+
+// refreshSchedule fetches and publishes the schedule of a device whose family
+// has one, retiring entities for programs that no longer exist.
+func (b *Bridge) refreshSchedule(device *pentaircloud.Device) error {
+	family, ok := devicetypes.ForDevice(device)
+
+	if !ok || family.Schedule == nil {
+		return nil
+	}
+
+	schedule, err := b.apiClient.GetSchedule(device.DeviceID)
+
+	if err != nil {
+		return err
+	}
+
+	if previous := b.schedule(device.DeviceID); previous != nil {
+		for _, program := range previous.Programs {
+			if _, ok := schedule.Program(program.ID); ok {
+				continue
+			}
+
+			config := b.programConfig(device, program, b.ExperimentalControls)
+			if _, err := b.mqttClient.PublishDiscovery(b.configTopic(config), []byte{}); err != nil {
+				return err
+			}
+		}
+	}
+
+	b.mu.Lock()
+	b.schedules[device.DeviceID] = schedule
+	b.mu.Unlock()
+
+	for _, program := range schedule.Programs {
+		config := b.programConfig(device, program, b.ExperimentalControls)
+		message, err := json.Marshal(config.Config)
+
+		if err != nil {
+			return fmt.Errorf("failed to marshal program config: %s", err)
+		}
+
+		if _, err := b.mqttClient.PublishDiscovery(b.configTopic(config), message); err != nil {
+			return err
+		}
+
+		other := b.programConfig(device, program, !b.ExperimentalControls)
+		if _, err := b.mqttClient.PublishDiscovery(b.configTopic(other), []byte{}); err != nil {
+			return err
+		}
+	}
+
+	return b.publishSchedule(device, schedule)
+}
+
+// publishSchedule publishes the programs keyed by ID, which is what the
+// program entities read their state and attributes from.
+func (b *Bridge) publishSchedule(device *pentaircloud.Device, schedule *pentaircloud.Schedule) error {
+	programs := map[string]pentaircloud.ScheduleProgram{}
+
+	for _, program := range schedule.Programs {
+		programs[strconv.Itoa(program.ID)] = program
+	}
+
+	message, err := json.Marshal(programs)
+
+	if err != nil {
+		return fmt.Errorf("failed to marshal schedule: %s", err)
+	}
+
+	_, err = b.mqttClient.PublishState(sensor.ScheduleTopic(b.topics, device), message)
+
+	return err
+}
+
+// programConfig returns the entity for a program, a switch if writable or
+// else a binary sensor.
+func (b *Bridge) programConfig(device *pentaircloud.Device, program pentaircloud.ScheduleProgram, writable bool) entityConfig {
+	name := program.Name
+
+	if name == "" {
+		name = fmt.Sprintf("Program %d", program.ID)
+	}
+
+	if !writable {
+		config := sensor.GenerateProgramBinarySensorConfig(b.topics, device, name, program.ID)
+
+		return entityConfig{Component: devicetypes.BinarySensor, UniqueID: config.UniqueID, Config: &config}
+	}
+
+	config := sensor.GenerateProgramSwitchConfig(b.topics, device, name, program.ID)
+
+	return entityConfig{Component: devicetypes.Switch, UniqueID: config.UniqueID, Config: &config}
+}
+
+// scheduleConfigs returns the entities for the device's known programs.
+func (b *Bridge) scheduleConfigs(device *pentaircloud.Device) []entityConfig {
+	schedule := b.schedule(device.DeviceID)
+
+	if schedule == nil {
+		return nil
+	}
+
+	configs := make([]entityConfig, 0, len(schedule.Programs))
+
+	for _, program := range schedule.Programs {
+		configs = append(configs, b.programConfig(device, program, b.ExperimentalControls))
+	}
+
+	return configs
+}
+
+// handleScheduleCommand applies a command sent to a device's schedule. ON or
+// OFF on pentairhome/<device ID>/schedule/<program ID>/set enables or disables
+// a program, and a JSON program on pentairhome/<device ID>/schedule/set
+// replaces the program with the same ID or adds it.
+func (b *Bridge) handleScheduleCommand(device *pentaircloud.Device, setting string, command mqtt.Command) error {
+	if !b.ExperimentalControls {
+		return fmt.Errorf("schedules are read only unless experimental_controls is turned on")
+	}
+
+	family, ok := devicetypes.ForDevice(device)
+	current := b.schedule(device.DeviceID)
+
+	if !ok || family.Schedule == nil || current == nil {
+		return fmt.Errorf("device %s has no schedule", device.DeviceID)
+	}
+
+	schedule := pentaircloud.Schedule{Programs: append([]pentaircloud.ScheduleProgram(nil), current.Programs...)}
+
+	if setting == "" {
+		var program pentaircloud.ScheduleProgram
+
+		if err := json.Unmarshal([]byte(command.Payload), &program); err != nil {
+			return fmt.Errorf("invalid program: %s", err)
+		}
+
+		if existing, ok := schedule.Program(program.ID); ok {
+			*existing = program
+		} else {
+			schedule.Programs = append(schedule.Programs, program)
+		}
+	} else {
+		id, err := strconv.Atoi(setting)
+
+		if err != nil {
+			return fmt.Errorf("invalid program %q", setting)
+		}
+
+		program, ok := schedule.Program(id)
+
+		if !ok {
+			return fmt.Errorf("device %s has no program %d", device.DeviceID, id)
+		}
+
+		switch strings.ToUpper(strings.TrimSpace(command.Payload)) {
+		case "ON":
+			program.Enabled = true
+		case "OFF":
+			program.Enabled = false
+		default:
+			return fmt.Errorf("invalid payload %q for program %d, expected ON or OFF", command.Payload, id)
+		}
+	}
+
+	if err := schedule.Validate(family.Schedule.Speeds(device)); err != nil {
+		return err
+	}
+
+	log.Printf("Updating schedule of %s", device.DeviceID)
+
+	if err := b.apiClient.UpdateSchedule(device.DeviceID, schedule); err != nil {
+		return err
+	}
+
+	b.commandSent()
+
+	return b.refreshSchedule(device)
+}
+
+func (b *Bridge) schedule(deviceID string) *pentaircloud.Schedule {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.schedules[deviceID]
+}
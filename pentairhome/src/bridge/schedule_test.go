@@ -0,0 +1,129 @@
+package bridge
+
+import (
+	"pentairhome/mqtt"
+	"pentairhome/pentaircloud"
+	"reflect"
+	"testing"
+)
+
+func TestHandleScheduleCommand(t *testing.T) {
+	pump := pentaircloud.ListDevice{DeviceID: "pump", ProductInfo: pentaircloud.ProductInfo{Model: "IntelliConnect"}}
+	morning := pentaircloud.ScheduleProgram{ID: 1, Name: "Morning", Enabled: true, Start: "08:00", End: "12:00", Speed: 2000, Days: []int{1, 2, 3, 4, 5}}
+	evening := pentaircloud.ScheduleProgram{ID: 2, Name: "Evening", Start: "18:00", End: "20:00", Speed: 1500, Days: []int{0, 6}}
+
+	disabled := morning
+	disabled.Enabled = false
+
+	faster := evening
+	faster.Speed = 2500
+
+	night := pentaircloud.ScheduleProgram{ID: 3, Name: "Night", Start: "22:00", End: "02:00", Speed: 1000, Days: []int{}}
+
+	tests := []struct {
+		name     string
+		topic    string
+		payload  string
+		controls bool
+		// want is the programs sent to Pentair, or nil if the command must be
+		// rejected without sending anything
+		want []pentaircloud.ScheduleProgram
+	}{
+		{"disable a program", "pentairhome/pump/schedule/1/set", "OFF", true, []pentaircloud.ScheduleProgram{disabled, evening}},
+		{"enable an enabled program", "pentairhome/pump/schedule/1/set", "on", true, []pentaircloud.ScheduleProgram{morning, evening}},
+		{"invalid switch payload", "pentairhome/pump/schedule/1/set", "toggle", true, nil},
+		{"unknown program", "pentairhome/pump/schedule/4/set", "ON", true, nil},
+		{"replace a program", "pentairhome/pump/schedule/set", `{"id": 2, "name": "Evening", "startTime": "18:00", "endTime": "20:00", "speed": 2500, "days": [0, 6]}`, true, []pentaircloud.ScheduleProgram{morning, faster}},
+		{"add a program", "pentairhome/pump/schedule/set", `{"id": 3, "name": "Night", "startTime": "22:00", "endTime": "02:00", "speed": 1000, "days": []}`, true, []pentaircloud.ScheduleProgram{morning, evening, night}},
+		{"invalid program", "pentairhome/pump/schedule/set", `{"id": 2, "startTime": "18:00", "endTime": "18:00", "speed": 1500}`, true, nil},
+		{"speed outside the reported range", "pentairhome/pump/schedule/set", `{"id": 2, "startTime": "18:00", "endTime": "20:00", "speed": 3200}`, true, nil},
+		{"malformed program", "pentairhome/pump/schedule/set", `{"id":`, true, nil},
+		{"without experimental controls", "pentairhome/pump/schedule/1/set", "OFF", false, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			api := &fakeAPI{
+				listed: []pentaircloud.ListDevice{pump},
+				fields: map[string]pentaircloud.DeviceField{
+					"ifs3": {Value: "500"},
+					"ifs5": {Value: "2000", Min: "1000", Max: "3000"},
+				},
+				schedule: pentaircloud.Schedule{Programs: []pentaircloud.ScheduleProgram{morning, evening}},
+			}
+
+			publisher := &fakePublisher{discovery: map[string]string{}}
+			b := newBridge(publisher, mqtt.Topics{Base: "pentairhome", DiscoveryPrefix: "homeassistant"}, api)
+			b.ExperimentalControls = test.controls
+
+			if err := b.discover(); err != nil {
+				t.Fatalf("discover() error = %s", err)
+			}
+
+			publisher.states = nil
+
+			err := b.handleCommand(mqtt.Command{Topic: test.topic, Payload: test.payload})
+
+			if test.want == nil {
+				if err == nil {
+					t.Error("handleCommand() expected an error")
+				}
+
+				if len(api.schedules) != 0 || b.lastCommand.Load() != 0 {
+					t.Errorf("sent %v for a rejected command", api.schedules)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("handleCommand() error = %s", err)
+			}
+
+			if len(api.schedules) != 1 || !reflect.DeepEqual(api.schedules[0].Programs, test.want) {
+				t.Errorf("sent %v, want %v", api.schedules, test.want)
+			}
+
+			if b.lastCommand.Load() == 0 {
+				t.Error("expected the command to make the poller poll sooner")
+			}
+
+			// The schedule is fetched and published again so the result shows
+			// straight away
+			if !reflect.DeepEqual(publisher.states, []string{"pentairhome/pump/schedule"}) {
+				t.Errorf("published state to %v after the command, want the schedule", publisher.states)
+			}
+		})
+	}
+}
+
+func TestProgramEntities(t *testing.T) {
+	pump := pentaircloud.ListDevice{DeviceID: "pump", ProductInfo: pentaircloud.ProductInfo{Model: "IntelliConnect"}}
+	switchTopic := "homeassistant/switch/ph_pump_program1/config"
+	sensorTopic := "homeassistant/binary_sensor/ph_pump_program1/config"
+
+	for _, enabled := range []bool{false, true} {
+		api := &fakeAPI{listed: []pentaircloud.ListDevice{pump}, schedule: pentaircloud.Schedule{Programs: []pentaircloud.ScheduleProgram{{ID: 1, Name: "Morning"}}}}
+		publisher := &fakePublisher{discovery: map[string]string{}}
+		b := newBridge(publisher, mqtt.Topics{Base: "pentairhome", DiscoveryPrefix: "homeassistant"}, api)
+		b.ExperimentalControls = enabled
+
+		if err := b.discover(); err != nil {
+			t.Fatalf("discover() error = %s", err)
+		}
+
+		published, cleared := sensorTopic, switchTopic
+
+		if enabled {
+			published, cleared = switchTopic, sensorTopic
+		}
+
+		if payload := publisher.discovery[published]; payload == "" {
+			t.Errorf("with experimental controls %t, expected a config on %s", enabled, published)
+		}
+
+		if payload, ok := publisher.discovery[cleared]; !ok || payload != "" {
+			t.Errorf("with experimental controls %t, expected %s to be cleared, got %q", enabled, cleared, payload)
+		}
+	}
+}
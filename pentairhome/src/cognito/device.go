@@ -0,0 +1,280 @@
+package cognito
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"pentairhome/config"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	cip "github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+)
+
+// The cognito-srp library only implements user SRP, so the device variant
+// below reimplements the same group parameters and key derivation.
+// https://github.com/aws/amazon-cognito-identity-js/blob/master/src/AuthenticationHelper.js
+const (
+	srpNHex = "FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD1" +
+		"29024E088A67CC74020BBEA63B139B22514A08798E3404DD" +
+		"EF9519B3CD3A431B302B0A6DF25F14374FE1356D6D51C245" +
+		"E485B576625E7EC6F44C42E9A637ED6B0BFF5CB6F406B7ED" +
+		"EE386BFB5A899FA5AE9F24117C4B1FE649286651ECE45B3D" +
+		"C2007CB8A163BF0598DA48361C55D39A69163FA8FD24CF5F" +
+		"83655D23DCA3AD961C62F356208552BB9ED529077096966D" +
+		"670C354E4ABC9804F1746C08CA18217C32905E462E36CE3B" +
+		"E39E772C180E86039B2783A2EC07A28FB5C55DF06F4C52C9" +
+		"DE2BCBF6955817183995497CEA956AE515D2261898FA0510" +
+		"15728E5A8AAAC42DAD33170D04507A33A85521ABDF1CBA64" +
+		"ECFB850458DBEF0A8AEA71575D060C7DB3970F85A6E1E4C7" +
+		"ABF5AE8CDB0933D71E8C94E04A25619DCEE3D2261AD2EE6B" +
+		"F12FFA06D98A0864D87602733EC86A64521F2B18177B200C" +
+		"BBE117577A615D6C770988C0BAD946E208E24FA074E5AB31" +
+		"43DB5BFCE0FD108E4B82D120A93AD2CAFFFFFFFFFFFFFFFF"
+	srpGHex      = "2"
+	srpInfoBits  = "Caldera Derived Key"
+	deviceName   = "Home Assistant Pentair Home add-on"
+	srpTimestamp = "Mon Jan 2 15:04:05 MST 2006"
+)
+
+var (
+	srpN = mustHexToBig(srpNHex)
+	srpG = mustHexToBig(srpGHex)
+	srpK = mustHexToBig(hexHash("00" + srpNHex + "0" + srpGHex))
+)
+
+// RememberedDevice is a device Cognito has confirmed for this add-on. The
+// password is generated locally and never leaves the add-on; Cognito only
+// holds a verifier derived from it.
+type RememberedDevice struct {
+	Key      string
+	GroupKey string
+	Password string
+}
+
+// ConfirmDevice registers the device Cognito created during login and marks
+// it as remembered so later logins can use device SRP.
+func ConfirmDevice(ctx context.Context, endpoints config.Configuration, accessToken string, metadata *types.NewDeviceMetadataType) (*RememberedDevice, error) {
+	if metadata == nil || metadata.DeviceKey == nil || metadata.DeviceGroupKey == nil {
+		return nil, fmt.Errorf("login did not return device metadata")
+	}
+
+	device := &RememberedDevice{
+		Key:      *metadata.DeviceKey,
+		GroupKey: *metadata.DeviceGroupKey,
+	}
+
+	passwordBytes, err := randomBytes(40)
+
+	if err != nil {
+		return nil, err
+	}
+
+	device.Password = base64.StdEncoding.EncodeToString(passwordBytes)
+
+	salt, verifier, err := device.verifier()
+
+	if err != nil {
+		return nil, err
+	}
+
+	saltBytes, _ := hex.DecodeString(salt)
+	verifierBytes, _ := hex.DecodeString(padHex(verifier.Text(16)))
+
+	cipClient, err := newIdentityProviderClient(ctx, endpoints)
+
+	if err != nil {
+		return nil, err
+	}
+
+	confirmResp, err := cipClient.ConfirmDevice(ctx, &cip.ConfirmDeviceInput{
+		AccessToken: aws.String(accessToken),
+		DeviceKey:   aws.String(device.Key),
+		DeviceName:  aws.String(deviceName),
+		DeviceSecretVerifierConfig: &types.DeviceSecretVerifierConfigType{
+			PasswordVerifier: aws.String(base64.StdEncoding.EncodeToString(verifierBytes)),
+			Salt:             aws.String(base64.StdEncoding.EncodeToString(saltBytes)),
+		},
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to confirm device: %s", err)
+	}
+
+	if confirmResp.UserConfirmationNecessary {
+		if _, err := cipClient.UpdateDeviceStatus(ctx, &cip.UpdateDeviceStatusInput{
+			AccessToken:            aws.String(accessToken),
+			DeviceKey:              aws.String(device.Key),
+			DeviceRememberedStatus: types.DeviceRememberedStatusTypeRemembered,
+		}); err != nil {
+			return nil, fmt.Errorf("failed to remember device: %s", err)
+		}
+	}
+
+	return device, nil
+}
+
+// verifier generates a random salt and the SRP verifier Cognito stores for
+// the device password.
+func (device RememberedDevice) verifier() (string, *big.Int, error) {
+	saltBytes, err := randomBytes(16)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	salt := padHex(hex.EncodeToString(saltBytes))
+	x := mustHexToBig(hexHash(salt + device.passwordHash()))
+
+	return salt, new(big.Int).Exp(srpG, x, srpN), nil
+}
+
+func (device RememberedDevice) passwordHash() string {
+	return hashSha256([]byte(device.GroupKey + device.Key + ":" + device.Password))
+}
+
+// deviceSRP answers the DEVICE_SRP_AUTH and DEVICE_PASSWORD_VERIFIER
+// challenges for a remembered device.
+type deviceSRP struct {
+	device RememberedDevice
+	a      *big.Int
+	bigA   *big.Int
+}
+
+func newDeviceSRP(device RememberedDevice) (*deviceSRP, error) {
+	aBytes, err := randomBytes(128)
+
+	if err != nil {
+		return nil, err
+	}
+
+	a := new(big.Int).Mod(new(big.Int).SetBytes(aBytes), srpN)
+	bigA := new(big.Int).Exp(srpG, a, srpN)
+
+	if bigA.Sign() == 0 {
+		return nil, fmt.Errorf("generated an invalid device srp value")
+	}
+
+	return &deviceSRP{
+		device: device,
+		a:      a,
+		bigA:   bigA,
+	}, nil
+}
+
+func (d *deviceSRP) authChallenge(username string) map[string]string {
+	return map[string]string{
+		"USERNAME":   username,
+		"DEVICE_KEY": d.device.Key,
+		"SRP_A":      d.bigA.Text(16),
+	}
+}
+
+func (d *deviceSRP) passwordVerifierChallenge(parameters map[string]string, ts time.Time) (map[string]string, error) {
+	bigB, ok := new(big.Int).SetString(parameters["SRP_B"], 16)
+
+	if !ok || new(big.Int).Mod(bigB, srpN).Sign() == 0 {
+		return nil, fmt.Errorf("invalid challenge parameter 'SRP_B'")
+	}
+
+	salt, ok := new(big.Int).SetString(parameters["SALT"], 16)
+
+	if !ok {
+		return nil, fmt.Errorf("invalid challenge parameter 'SALT'")
+	}
+
+	secretBlock, err := base64.StdEncoding.DecodeString(parameters["SECRET_BLOCK"])
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode challenge parameter 'SECRET_BLOCK', %s", err)
+	}
+
+	u := mustHexToBig(hexHash(padHex(d.bigA.Text(16)) + padHex(bigB.Text(16))))
+	x := mustHexToBig(hexHash(padHex(salt.Text(16)) + d.device.passwordHash()))
+
+	base := new(big.Int).Sub(bigB, new(big.Int).Mul(srpK, new(big.Int).Exp(srpG, x, srpN)))
+	base.Mod(base, srpN)
+	exponent := new(big.Int).Add(d.a, new(big.Int).Mul(u, x))
+	s := new(big.Int).Exp(base, exponent, srpN)
+
+	key := computeHKDF(padHex(s.Text(16)), padHex(u.Text(16)))
+	timestamp := ts.In(time.UTC).Format(srpTimestamp)
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(d.device.GroupKey + d.device.Key))
+	mac.Write(secretBlock)
+	mac.Write([]byte(timestamp))
+
+	return map[string]string{
+		"TIMESTAMP":                   timestamp,
+		"USERNAME":                    parameters["USERNAME"],
+		"PASSWORD_CLAIM_SECRET_BLOCK": parameters["SECRET_BLOCK"],
+		"PASSWORD_CLAIM_SIGNATURE":    base64.StdEncoding.EncodeToString(mac.Sum(nil)),
+		"DEVICE_KEY":                  d.device.Key,
+	}, nil
+}
+
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("failed to generate random bytes: %s", err)
+	}
+
+	return b, nil
+}
+
+func hashSha256(buf []byte) string {
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:])
+}
+
+func hexHash(hexStr string) string {
+	buf, _ := hex.DecodeString(hexStr)
+	return hashSha256(buf)
+}
+
+func mustHexToBig(hexStr string) *big.Int {
+	i, ok := new(big.Int).SetString(hexStr, 16)
+
+	if !ok {
+		panic(fmt.Sprintf("unable to convert %q to big.Int", hexStr))
+	}
+
+	return i
+}
+
+// padHex makes a hex string a whole number of bytes and keeps it positive
+// when read back as a two's complement number, matching the Cognito SDKs.
+func padHex(hexStr string) string {
+	if len(hexStr)%2 == 1 {
+		return "0" + hexStr
+	}
+
+	if strings.ContainsAny(hexStr[:1], "89ABCDEFabcdef") {
+		return "00" + hexStr
+	}
+
+	return hexStr
+}
+
+func computeHKDF(ikm, salt string) []byte {
+	ikmBytes, _ := hex.DecodeString(ikm)
+	saltBytes, _ := hex.DecodeString(salt)
+
+	extractor := hmac.New(sha256.New, saltBytes)
+	extractor.Write(ikmBytes)
+	prk := extractor.Sum(nil)
+
+	expander := hmac.New(sha256.New, prk)
+	expander.Write(append([]byte(srpInfoBits), byte(1)))
+
+	return expander.Sum(nil)[:16]
+}
@@ -0,0 +1,87 @@
+package cognito
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+)
+
+// MFARequiredError is returned when Cognito asks for an MFA code that the
+// add-on has not been configured to supply. For SMS MFA, Pending is the
+// challenge the code that was sent answers.
+type MFARequiredError struct {
+	Challenge   types.ChallengeNameType
+	Destination string
+	Pending     *PendingMFA
+}
+
+func (e *MFARequiredError) Error() string {
+	if e.Challenge == types.ChallengeNameTypeSoftwareTokenMfa {
+		return "account requires authenticator app MFA: set pentairhome_totp_secret to the secret key shown when the authenticator was registered"
+	}
+
+	return fmt.Sprintf("account requires SMS MFA: set pentairhome_mfa_code to the code just sent to %s and restart the add-on within %s, or switch the account to authenticator app MFA and set pentairhome_totp_secret so logins can run unattended", e.Destination, smsChallengeValidity)
+}
+
+// NewPasswordRequiredError is returned when Pentair has forced a password
+// reset and no replacement password has been configured.
+type NewPasswordRequiredError struct {
+	RequiredAttributes string
+}
+
+func (e *NewPasswordRequiredError) Error() string {
+	if e.RequiredAttributes != "" && e.RequiredAttributes != "[]" {
+		return fmt.Sprintf("account requires a new password and the attributes %s, which can only be set from the Pentair Home app", e.RequiredAttributes)
+	}
+
+	return "account requires a new password: set pentairhome_new_password, restart the add-on and then move the new password into pentairhome_password"
+}
+
+// UnsupportedChallengeError is returned for any Cognito challenge the login
+// flow does not know how to answer.
+type UnsupportedChallengeError struct {
+	Challenge types.ChallengeNameType
+}
+
+func (e *UnsupportedChallengeError) Error() string {
+	return fmt.Sprintf("unexpected challenge name: %s", e.Challenge)
+}
+
+// DeviceAuthError is returned when Cognito rejects, or the add-on cannot
+// answer, the device SRP challenges for a remembered device.
+type DeviceAuthError struct {
+	Err error
+}
+
+func (e *DeviceAuthError) Error() string {
+	return fmt.Sprintf("device authentication failed: %s", e.Err)
+}
+
+func (e *DeviceAuthError) Unwrap() error {
+	return e.Err
+}
+
+// LoginRejectedError is returned when Cognito rejects the configured username
+// and password.
+type LoginRejectedError struct {
+	Err error
+}
+
+func (e *LoginRejectedError) Error() string {
+	return fmt.Sprintf("login rejected, check pentairhome_username and pentairhome_password: %s", e.Err)
+}
+
+func (e *LoginRejectedError) Unwrap() error {
+	return e.Err
+}
+
+// IsFatal reports whether err is a login failure that retrying cannot fix
+// until the add-on configuration is changed.
+func IsFatal(err error) bool {
+	var mfaRequired *MFARequiredError
+	var newPasswordRequired *NewPasswordRequiredError
+	var rejected *LoginRejectedError
+
+	return errors.As(err, &mfaRequired) || errors.As(err, &newPasswordRequired) || errors.As(err, &rejected)
+}
@@ -2,51 +2,119 @@ package cognito
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"pentairhome/config"
+	"sync"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	awsconfig "github.com/aws/aws-sdk-go-v2/config"
 	ci "github.com/aws/aws-sdk-go-v2/service/cognitoidentity"
 	cit "github.com/aws/aws-sdk-go-v2/service/cognitoidentity/types"
-	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
 )
 
-func GetCredentialsFromAuthentication(ctx context.Context, authenticationResult *types.AuthenticationResultType) (*cit.Credentials, error) {
-	appConfiguration := config.FetchConfiguration()
+// IdentityCredentialsProvider is an aws.CredentialsProvider that exchanges the
+// current user pool ID token for temporary identity pool credentials. Wrap it
+// in an aws.CredentialsCache so credentials are only fetched again once they
+// are close to expiring. The identity ID is kept in the session store so that
+// restarts skip the GetId lookup.
+type IdentityCredentialsProvider struct {
+	tokens *TokenManager
+
+	mu sync.Mutex
+}
+
+func NewIdentityCredentialsProvider(tokens *TokenManager) *IdentityCredentialsProvider {
+	return &IdentityCredentialsProvider{
+		tokens: tokens,
+	}
+}
+
+func (p *IdentityCredentialsProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	appConfiguration := p.tokens.Endpoints()
+
+	idToken, err := p.tokens.IdToken(ctx)
+
+	if err != nil {
+		return aws.Credentials{}, fmt.Errorf("failed to get id token: %w", err)
+	}
 
 	cfg, err := awsconfig.LoadDefaultConfig(
 		ctx,
 		awsconfig.WithRegion(appConfiguration.AWSRegion),
+		awsconfig.WithCredentialsProvider(aws.AnonymousCredentials{}),
 	)
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to load configuration, %v", err)
+		return aws.Credentials{}, fmt.Errorf("failed to load configuration, %v", err)
 	}
 
-	cognitoIdentityService := ci.NewFromConfig(cfg)
+	cognitoIdentityService := ci.NewFromConfig(cfg, func(options *ci.Options) {
+		if appConfiguration.CognitoIdentityEndpoint != "" {
+			options.BaseEndpoint = aws.String(appConfiguration.CognitoIdentityEndpoint)
+		}
+	})
 
 	logins := map[string]string{
-		appConfiguration.GetLoginKey(): *authenticationResult.IdToken,
+		appConfiguration.GetLoginKey(): idToken,
 	}
 
-	idRes, err := cognitoIdentityService.GetId(ctx, &ci.GetIdInput{
-		IdentityPoolId: aws.String(appConfiguration.AWSIdentityPoolId),
-		Logins:         logins,
-	})
+	session := p.tokens.Session()
+	identityID := session.Get().IdentityID
 
-	if err != nil {
-		return nil, fmt.Errorf("failed to get id: %s", err)
+	if identityID == "" {
+		idRes, err := cognitoIdentityService.GetId(ctx, &ci.GetIdInput{
+			IdentityPoolId: aws.String(appConfiguration.AWSIdentityPoolId),
+			Logins:         logins,
+		})
+
+		if err != nil {
+			return aws.Credentials{}, fmt.Errorf("failed to get id: %s", err)
+		}
+
+		identityID = *idRes.IdentityId
+		session.Update(func(session *Session) {
+			session.IdentityID = identityID
+		})
 	}
 
 	credsRes, err := cognitoIdentityService.GetCredentialsForIdentity(ctx, &ci.GetCredentialsForIdentityInput{
-		IdentityId: idRes.IdentityId,
+		IdentityId: aws.String(identityID),
 		Logins:     logins,
 	})
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to get credentials: %s", err)
+		var notFound *cit.ResourceNotFoundException
+
+		if errors.As(err, &notFound) {
+			// The saved identity no longer exists, so look it up again next time.
+			session.Update(func(session *Session) {
+				session.IdentityID = ""
+			})
+		}
+
+		return aws.Credentials{}, fmt.Errorf("failed to get credentials: %s", err)
+	}
+
+	creds := credsRes.Credentials
+
+	if creds == nil || creds.AccessKeyId == nil || creds.SecretKey == nil || creds.SessionToken == nil {
+		return aws.Credentials{}, fmt.Errorf("identity pool returned incomplete credentials")
+	}
+
+	result := aws.Credentials{
+		AccessKeyID:     *creds.AccessKeyId,
+		SecretAccessKey: *creds.SecretKey,
+		SessionToken:    *creds.SessionToken,
+		Source:          "CognitoIdentity",
+	}
+
+	if creds.Expiration != nil {
+		result.CanExpire = true
+		result.Expires = *creds.Expiration
 	}
 
-	return credsRes.Credentials, nil
+	return result, nil
 }
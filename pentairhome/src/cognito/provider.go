@@ -2,7 +2,9 @@ package cognito
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
 	"pentairhome/config"
 	"time"
 
@@ -13,14 +15,35 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
 )
 
-func AuthenticateWithUsernameAndPassword(ctx context.Context, username, password string) (*types.AuthenticationResultType, error) {
-	configuration := config.FetchConfiguration()
+// Login holds everything needed to complete a Cognito login unattended.
+// Only Username and Password are always required; the rest are used to answer
+// the MFA and forced password reset challenges when Cognito issues them.
+// SMSCode only answers the challenge it was sent for, see ResumeSMSLogin.
+type Login struct {
+	Username    string
+	Password    string
+	TOTPSecret  string
+	SMSCode     string
+	NewPassword string
+}
+
+// Cognito never chains more than a handful of challenges, so anything beyond
+// this is treated as a loop.
+const maxAuthChallenges = 5
+
+// smsChallengeValidity is how long Cognito accepts the answer to a challenge,
+// which is three minutes unless the app client is configured otherwise.
+const smsChallengeValidity = 3 * time.Minute
 
+// AuthenticateWithUsernameAndPassword performs a user SRP login. When device is
+// set Cognito is told which remembered device is logging in and the device SRP
+// challenges are answered with it.
+func AuthenticateWithUsernameAndPassword(ctx context.Context, endpoints config.Configuration, login Login, device *RememberedDevice) (*types.AuthenticationResultType, error) {
 	csrp, err := cognitosrp.NewCognitoSRP(
-		username,
-		password,
-		configuration.AWSUserPoolID,
-		configuration.AWSClientID,
+		login.Username,
+		login.Password,
+		endpoints.AWSUserPoolID,
+		endpoints.AWSClientID,
 		nil,
 	)
 
@@ -28,30 +51,35 @@ func AuthenticateWithUsernameAndPassword(ctx context.Context, username, password
 		return nil, fmt.Errorf("failed to create cognito srp: %s", err)
 	}
 
-	cfg, err := awsConfig.LoadDefaultConfig(
-		ctx,
-		awsConfig.WithRegion(config.FetchConfiguration().AWSRegion),
-		awsConfig.WithCredentialsProvider(aws.AnonymousCredentials{}),
-	)
+	cipClient, err := newIdentityProviderClient(ctx, endpoints)
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to load configuration: %s", err)
+		return nil, err
 	}
 
-	cipClient := cip.NewFromConfig(cfg)
+	var deviceAuth *deviceSRP
+	authParams := csrp.GetAuthParams()
+
+	if device != nil {
+		if deviceAuth, err = newDeviceSRP(*device); err != nil {
+			return nil, err
+		}
+
+		authParams["DEVICE_KEY"] = device.Key
+	}
 
 	authResp, err := cipClient.InitiateAuth(ctx, &cip.InitiateAuthInput{
 		AuthFlow:       types.AuthFlowTypeUserSrpAuth,
 		ClientId:       aws.String(csrp.GetClientId()),
-		AuthParameters: csrp.GetAuthParams(),
+		AuthParameters: authParams,
 	})
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to initiate auth: %s", err)
+		return nil, fmt.Errorf("failed to initiate auth: %w", err)
 	}
 
 	if authResp.ChallengeName != types.ChallengeNameTypePasswordVerifier {
-		return nil, fmt.Errorf("unexpected challenge name: %s", authResp.ChallengeName)
+		return nil, &UnsupportedChallengeError{Challenge: authResp.ChallengeName}
 	}
 
 	challengeResponses, err := csrp.PasswordVerifierChallenge(authResp.ChallengeParameters, time.Now())
@@ -60,6 +88,10 @@ func AuthenticateWithUsernameAndPassword(ctx context.Context, username, password
 		return nil, fmt.Errorf("failed to respond to password verifier challenge: %s", err)
 	}
 
+	if device != nil {
+		challengeResponses["DEVICE_KEY"] = device.Key
+	}
+
 	resp, err := cipClient.RespondToAuthChallenge(ctx, &cip.RespondToAuthChallengeInput{
 		ChallengeName:      types.ChallengeNameTypePasswordVerifier,
 		ChallengeResponses: challengeResponses,
@@ -67,8 +99,224 @@ func AuthenticateWithUsernameAndPassword(ctx context.Context, username, password
 	})
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to respond to auth challenge: %s", err)
+		return nil, fmt.Errorf("failed to respond to auth challenge: %w", err)
+	}
+
+	return respondToChallenges(ctx, cipClient, csrp.GetClientId(), login, deviceAuth, resp)
+}
+
+// ResumeSMSLogin completes a login that stopped at an SMS MFA challenge by
+// answering it with login.SMSCode, followed by any challenges after it.
+func ResumeSMSLogin(ctx context.Context, endpoints config.Configuration, login Login, device *RememberedDevice, pending PendingMFA) (*types.AuthenticationResultType, error) {
+	cipClient, err := newIdentityProviderClient(ctx, endpoints)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var deviceAuth *deviceSRP
+
+	if device != nil {
+		if deviceAuth, err = newDeviceSRP(*device); err != nil {
+			return nil, err
+		}
+	}
+
+	return respondToChallenges(ctx, cipClient, endpoints.AWSClientID, login, deviceAuth, &cip.RespondToAuthChallengeOutput{
+		ChallengeName:       types.ChallengeNameTypeSmsMfa,
+		ChallengeParameters: map[string]string{"USER_ID_FOR_SRP": pending.UserID},
+		Session:             aws.String(pending.Session),
+	})
+}
+
+// challengeResponder is the part of the Cognito client that answers auth
+// challenges.
+type challengeResponder interface {
+	RespondToAuthChallenge(ctx context.Context, params *cip.RespondToAuthChallengeInput, optFns ...func(*cip.Options)) (*cip.RespondToAuthChallengeOutput, error)
+}
+
+// respondToChallenges answers the challenges that follow the password
+// verifier until Cognito returns tokens.
+func respondToChallenges(ctx context.Context, client challengeResponder, clientID string, login Login, deviceAuth *deviceSRP, resp *cip.RespondToAuthChallengeOutput) (*types.AuthenticationResultType, error) {
+	for i := 0; resp.AuthenticationResult == nil; i++ {
+		if i == maxAuthChallenges {
+			return nil, fmt.Errorf("gave up after %d auth challenges", maxAuthChallenges)
+		}
+
+		challenge := resp.ChallengeName
+		challengeResponses, err := answerChallenge(login, deviceAuth, challenge, resp.ChallengeParameters)
+
+		var mfaRequired *MFARequiredError
+
+		if errors.As(err, &mfaRequired) && mfaRequired.Pending != nil {
+			mfaRequired.Pending.Session = aws.ToString(resp.Session)
+			mfaRequired.Pending.Expires = time.Now().Add(smsChallengeValidity)
+		}
+
+		if err != nil {
+			return nil, deviceAuthFailed(challenge, err)
+		}
+
+		resp, err = client.RespondToAuthChallenge(ctx, &cip.RespondToAuthChallengeInput{
+			ChallengeName:      challenge,
+			ChallengeResponses: challengeResponses,
+			ClientId:           aws.String(clientID),
+			Session:            resp.Session,
+		})
+
+		if err != nil {
+			return nil, deviceAuthFailed(challenge, fmt.Errorf("failed to respond to auth challenge: %w", err))
+		}
 	}
 
 	return resp.AuthenticationResult, nil
 }
+
+// deviceAuthFailed wraps err in a DeviceAuthError when it came from answering
+// one of the device SRP challenges.
+func deviceAuthFailed(challenge types.ChallengeNameType, err error) error {
+	if challenge == types.ChallengeNameTypeDeviceSrpAuth || challenge == types.ChallengeNameTypeDevicePasswordVerifier {
+		return &DeviceAuthError{Err: err}
+	}
+
+	return err
+}
+
+// answerChallenge builds the responses for the challenges Cognito can issue
+// after the password has been verified.
+func answerChallenge(login Login, deviceAuth *deviceSRP, challenge types.ChallengeNameType, parameters map[string]string) (map[string]string, error) {
+	username := login.Username
+
+	if userID, ok := parameters["USER_ID_FOR_SRP"]; ok {
+		username = userID
+	} else if userID, ok := parameters["USERNAME"]; ok {
+		username = userID
+	}
+
+	switch challenge {
+	case types.ChallengeNameTypeDeviceSrpAuth:
+		if deviceAuth == nil {
+			return nil, &UnsupportedChallengeError{Challenge: challenge}
+		}
+
+		return deviceAuth.authChallenge(username), nil
+	case types.ChallengeNameTypeDevicePasswordVerifier:
+		if deviceAuth == nil {
+			return nil, &UnsupportedChallengeError{Challenge: challenge}
+		}
+
+		return deviceAuth.passwordVerifierChallenge(parameters, time.Now())
+	case types.ChallengeNameTypeSoftwareTokenMfa:
+		if login.TOTPSecret == "" {
+			return nil, &MFARequiredError{Challenge: challenge}
+		}
+
+		code, err := GenerateTOTP(login.TOTPSecret, time.Now())
+
+		if err != nil {
+			return nil, err
+		}
+
+		log.Println("Answering authenticator app MFA challenge")
+
+		return map[string]string{
+			"USERNAME":                username,
+			"SOFTWARE_TOKEN_MFA_CODE": code,
+		}, nil
+	case types.ChallengeNameTypeSmsMfa:
+		if login.SMSCode == "" {
+			return nil, &MFARequiredError{
+				Challenge:   challenge,
+				Destination: parameters["CODE_DELIVERY_DESTINATION"],
+				Pending:     &PendingMFA{UserID: username},
+			}
+		}
+
+		log.Println("Answering SMS MFA challenge")
+
+		return map[string]string{
+			"USERNAME":     username,
+			"SMS_MFA_CODE": login.SMSCode,
+		}, nil
+	case types.ChallengeNameTypeSelectMfaType:
+		answer := types.ChallengeNameTypeSmsMfa
+
+		if login.TOTPSecret != "" {
+			answer = types.ChallengeNameTypeSoftwareTokenMfa
+		}
+
+		return map[string]string{
+			"USERNAME": username,
+			"ANSWER":   string(answer),
+		}, nil
+	case types.ChallengeNameTypeNewPasswordRequired:
+		requiredAttributes := parameters["requiredAttributes"]
+
+		if login.NewPassword == "" || (requiredAttributes != "" && requiredAttributes != "[]") {
+			return nil, &NewPasswordRequiredError{RequiredAttributes: requiredAttributes}
+		}
+
+		log.Println("Setting new password as requested by Cognito. Update pentairhome_password to the new password.")
+
+		return map[string]string{
+			"USERNAME":     username,
+			"NEW_PASSWORD": login.NewPassword,
+		}, nil
+	}
+
+	return nil, &UnsupportedChallengeError{Challenge: challenge}
+}
+
+// RefreshAuthentication exchanges a refresh token for a new ID and access
+// token using the REFRESH_TOKEN_AUTH flow. Cognito does not rotate the refresh
+// token, so the returned result has no RefreshToken set. Refresh tokens issued
+// to a remembered device are only accepted alongside its device key.
+func RefreshAuthentication(ctx context.Context, endpoints config.Configuration, refreshToken, deviceKey string) (*types.AuthenticationResultType, error) {
+	cipClient, err := newIdentityProviderClient(ctx, endpoints)
+
+	if err != nil {
+		return nil, err
+	}
+
+	authParams := map[string]string{
+		"REFRESH_TOKEN": refreshToken,
+	}
+
+	if deviceKey != "" {
+		authParams["DEVICE_KEY"] = deviceKey
+	}
+
+	authResp, err := cipClient.InitiateAuth(ctx, &cip.InitiateAuthInput{
+		AuthFlow:       types.AuthFlowTypeRefreshTokenAuth,
+		ClientId:       aws.String(endpoints.AWSClientID),
+		AuthParameters: authParams,
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to refresh auth: %w", err)
+	}
+
+	if authResp.AuthenticationResult == nil {
+		return nil, fmt.Errorf("unexpected challenge name during refresh: %s", authResp.ChallengeName)
+	}
+
+	return authResp.AuthenticationResult, nil
+}
+
+func newIdentityProviderClient(ctx context.Context, endpoints config.Configuration) (*cip.Client, error) {
+	cfg, err := awsConfig.LoadDefaultConfig(
+		ctx,
+		awsConfig.WithRegion(endpoints.AWSRegion),
+		awsConfig.WithCredentialsProvider(aws.AnonymousCredentials{}),
+	)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to load configuration: %s", err)
+	}
+
+	return cip.NewFromConfig(cfg, func(options *cip.Options) {
+		if endpoints.CognitoIDPEndpoint != "" {
+			options.BaseEndpoint = aws.String(endpoints.CognitoIDPEndpoint)
+		}
+	}), nil
+}
@@ -0,0 +1,224 @@
+package cognito
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	cip "github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+)
+
+// fakeResponder records the challenge answers it is sent and replies with
+// the next output, or repeats the challenge once they run out.
+type fakeResponder struct {
+	outputs []*cip.RespondToAuthChallengeOutput
+	inputs  []*cip.RespondToAuthChallengeInput
+}
+
+func (f *fakeResponder) RespondToAuthChallenge(ctx context.Context, params *cip.RespondToAuthChallengeInput, optFns ...func(*cip.Options)) (*cip.RespondToAuthChallengeOutput, error) {
+	f.inputs = append(f.inputs, params)
+
+	if len(f.outputs) == 0 {
+		return &cip.RespondToAuthChallengeOutput{ChallengeName: params.ChallengeName, Session: params.Session}, nil
+	}
+
+	output := f.outputs[0]
+	f.outputs = f.outputs[1:]
+
+	return output, nil
+}
+
+func TestRespondToChallenges(t *testing.T) {
+	const secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+	var mfaRequired *MFARequiredError
+	var newPasswordRequired *NewPasswordRequiredError
+	var unsupported *UnsupportedChallengeError
+
+	tests := []struct {
+		name       string
+		login      Login
+		device     bool
+		challenge  types.ChallengeNameType
+		parameters map[string]string
+		// responses lists the keys the answer must set, with the value
+		// expected or "" for any non-empty value
+		responses map[string]string
+		err       any
+	}{
+		{
+			name:       "device srp with a remembered device",
+			device:     true,
+			challenge:  types.ChallengeNameTypeDeviceSrpAuth,
+			parameters: map[string]string{"USERNAME": "user-id"},
+			responses:  map[string]string{"USERNAME": "user-id", "DEVICE_KEY": "device-key", "SRP_A": ""},
+		},
+		{
+			name:      "device srp without a remembered device",
+			challenge: types.ChallengeNameTypeDeviceSrpAuth,
+			err:       &unsupported,
+		},
+		{
+			name:       "authenticator app code",
+			login:      Login{TOTPSecret: secret},
+			challenge:  types.ChallengeNameTypeSoftwareTokenMfa,
+			parameters: map[string]string{"USER_ID_FOR_SRP": "user-id"},
+			responses:  map[string]string{"USERNAME": "user-id", "SOFTWARE_TOKEN_MFA_CODE": ""},
+		},
+		{
+			name:      "authenticator app code without a secret",
+			challenge: types.ChallengeNameTypeSoftwareTokenMfa,
+			err:       &mfaRequired,
+		},
+		{
+			name:       "sms code",
+			login:      Login{SMSCode: "123456"},
+			challenge:  types.ChallengeNameTypeSmsMfa,
+			parameters: map[string]string{"USER_ID_FOR_SRP": "user-id"},
+			responses:  map[string]string{"USERNAME": "user-id", "SMS_MFA_CODE": "123456"},
+		},
+		{
+			name:      "sms code not configured",
+			login:     Login{TOTPSecret: secret},
+			challenge: types.ChallengeNameTypeSmsMfa,
+			err:       &mfaRequired,
+		},
+		{
+			name:      "mfa type selection",
+			login:     Login{TOTPSecret: secret},
+			challenge: types.ChallengeNameTypeSelectMfaType,
+			responses: map[string]string{"USERNAME": "user", "ANSWER": "SOFTWARE_TOKEN_MFA"},
+		},
+		{
+			name:      "mfa type selection without a secret",
+			challenge: types.ChallengeNameTypeSelectMfaType,
+			responses: map[string]string{"USERNAME": "user", "ANSWER": "SMS_MFA"},
+		},
+		{
+			name:       "new password",
+			login:      Login{NewPassword: "new-password"},
+			challenge:  types.ChallengeNameTypeNewPasswordRequired,
+			parameters: map[string]string{"requiredAttributes": "[]"},
+			responses:  map[string]string{"USERNAME": "user", "NEW_PASSWORD": "new-password"},
+		},
+		{
+			name:      "new password not configured",
+			challenge: types.ChallengeNameTypeNewPasswordRequired,
+			err:       &newPasswordRequired,
+		},
+		{
+			name:       "new password with required attributes",
+			login:      Login{NewPassword: "new-password"},
+			challenge:  types.ChallengeNameTypeNewPasswordRequired,
+			parameters: map[string]string{"requiredAttributes": `["email"]`},
+			err:        &newPasswordRequired,
+		},
+		{
+			name:      "unknown challenge",
+			challenge: types.ChallengeNameTypeCustomChallenge,
+			err:       &unsupported,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.login.Username = "user"
+
+			var deviceAuth *deviceSRP
+
+			if test.device {
+				var err error
+				deviceAuth, err = newDeviceSRP(RememberedDevice{Key: "device-key", GroupKey: "group-key", Password: "device-password"})
+
+				if err != nil {
+					t.Fatalf("newDeviceSRP() error = %s", err)
+				}
+			}
+
+			responder := &fakeResponder{outputs: []*cip.RespondToAuthChallengeOutput{
+				{AuthenticationResult: authResult("challenged", "")},
+			}}
+
+			result, err := respondToChallenges(context.Background(), responder, "client", test.login, deviceAuth, &cip.RespondToAuthChallengeOutput{
+				ChallengeName:       test.challenge,
+				ChallengeParameters: test.parameters,
+				Session:             aws.String("session"),
+			})
+
+			if test.err != nil {
+				if !errors.As(err, test.err) {
+					t.Fatalf("respondToChallenges() error = %v, want %T", err, test.err)
+				}
+
+				if len(responder.inputs) != 0 {
+					t.Errorf("sent %d answers, want none", len(responder.inputs))
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("respondToChallenges() error = %s", err)
+			}
+
+			if aws.ToString(result.IdToken) != "challenged" {
+				t.Errorf("IdToken = %s, want challenged", aws.ToString(result.IdToken))
+			}
+
+			if len(responder.inputs) != 1 {
+				t.Fatalf("sent %d answers, want 1", len(responder.inputs))
+			}
+
+			input := responder.inputs[0]
+
+			if input.ChallengeName != test.challenge || aws.ToString(input.Session) != "session" || aws.ToString(input.ClientId) != "client" {
+				t.Errorf("answered %s with session %s for client %s, want %s with session session for client client", input.ChallengeName, aws.ToString(input.Session), aws.ToString(input.ClientId), test.challenge)
+			}
+
+			for key, expected := range test.responses {
+				value := input.ChallengeResponses[key]
+
+				if value == "" || (expected != "" && value != expected) {
+					t.Errorf("%s = %q, want %q", key, value, expected)
+				}
+			}
+		})
+	}
+}
+
+func TestRespondToChallengesKeepsSMSChallenge(t *testing.T) {
+	_, err := respondToChallenges(context.Background(), &fakeResponder{}, "client", Login{Username: "user"}, nil, &cip.RespondToAuthChallengeOutput{
+		ChallengeName:       types.ChallengeNameTypeSmsMfa,
+		ChallengeParameters: map[string]string{"USER_ID_FOR_SRP": "user-id", "CODE_DELIVERY_DESTINATION": "+*******1234"},
+		Session:             aws.String("session"),
+	})
+
+	var mfaRequired *MFARequiredError
+
+	if !errors.As(err, &mfaRequired) || mfaRequired.Pending == nil {
+		t.Fatalf("respondToChallenges() error = %v, want an MFARequiredError with the pending challenge", err)
+	}
+
+	if pending := mfaRequired.Pending; pending.Session != "session" || pending.UserID != "user-id" || !pending.Expires.After(time.Now()) {
+		t.Errorf("pending challenge = %+v, want session for user-id expiring later", pending)
+	}
+}
+
+func TestRespondToChallengesGivesUp(t *testing.T) {
+	responder := &fakeResponder{}
+
+	_, err := respondToChallenges(context.Background(), responder, "client", Login{Username: "user", TOTPSecret: "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"}, nil, &cip.RespondToAuthChallengeOutput{
+		ChallengeName: types.ChallengeNameTypeSelectMfaType,
+	})
+
+	if err == nil {
+		t.Fatal("respondToChallenges() expected an error when challenges never end")
+	}
+
+	if len(responder.inputs) != maxAuthChallenges {
+		t.Errorf("sent %d answers, want %d", len(responder.inputs), maxAuthChallenges)
+	}
+}
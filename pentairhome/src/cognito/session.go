@@ -0,0 +1,125 @@
+package cognito
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"pentairhome/persist"
+	"sync"
+	"time"
+)
+
+// Session is the part of a Cognito login that is kept across restarts so the
+// add-on can resume without a password login.
+type Session struct {
+	Username       string `json:"username"`
+	RefreshToken   string `json:"refresh_token,omitempty"`
+	IdentityID     string `json:"identity_id,omitempty"`
+	DeviceKey      string `json:"device_key,omitempty"`
+	DeviceGroupKey string `json:"device_group_key,omitempty"`
+	DevicePassword string `json:"device_password,omitempty"`
+	// PendingMFA is an SMS MFA challenge waiting for pentairhome_mfa_code
+	PendingMFA *PendingMFA `json:"pending_mfa,omitempty"`
+}
+
+// PendingMFA is an SMS MFA challenge left open by a login. The code Cognito
+// sends only answers the challenge it was sent for, so the challenge is kept
+// for the next start of the add-on to answer once the code is configured.
+type PendingMFA struct {
+	Session string    `json:"session"`
+	UserID  string    `json:"user_id"`
+	Expires time.Time `json:"expires"`
+}
+
+// Device returns the remembered device, or nil if this session has not
+// confirmed one.
+func (session Session) Device() *RememberedDevice {
+	if session.DeviceKey == "" || session.DeviceGroupKey == "" || session.DevicePassword == "" {
+		return nil
+	}
+
+	return &RememberedDevice{
+		Key:      session.DeviceKey,
+		GroupKey: session.DeviceGroupKey,
+		Password: session.DevicePassword,
+	}
+}
+
+func (session *Session) setDevice(device *RememberedDevice) {
+	if device == nil {
+		session.DeviceKey = ""
+		session.DeviceGroupKey = ""
+		session.DevicePassword = ""
+		return
+	}
+
+	session.DeviceKey = device.Key
+	session.DeviceGroupKey = device.GroupKey
+	session.DevicePassword = device.Password
+}
+
+// SessionStore keeps a Session in memory and writes every change to disk. An
+// empty path disables persistence.
+type SessionStore struct {
+	path string
+
+	mu      sync.Mutex
+	session Session
+}
+
+// LoadSessionStore reads the session saved at path. A missing or unreadable
+// file, or one saved for a different user, starts an empty session.
+func LoadSessionStore(path, username string) *SessionStore {
+	store := &SessionStore{
+		path:    path,
+		session: Session{Username: username},
+	}
+
+	if path == "" {
+		return store
+	}
+
+	var saved Session
+
+	if err := persist.ReadJSON(path, &saved); err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("Ignoring saved session: %s", err)
+		}
+
+		return store
+	}
+
+	if saved.Username != username {
+		log.Println("Ignoring saved session for a different user")
+		return store
+	}
+
+	log.Printf("Loaded saved session from %s", path)
+	store.session = saved
+
+	return store
+}
+
+func (s *SessionStore) Get() Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.session
+}
+
+// Update applies fn to the session and saves the result. Failing to save is
+// logged rather than returned as the in-memory session is still usable.
+func (s *SessionStore) Update(fn func(session *Session)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fn(&s.session)
+
+	if s.path == "" {
+		return
+	}
+
+	if err := persist.WriteJSON(s.path, s.session); err != nil {
+		log.Printf("Failed to save session: %s", err)
+	}
+}
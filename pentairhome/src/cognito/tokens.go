@@ -0,0 +1,250 @@
+package cognito
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"pentairhome/config"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+)
+
+// Tokens are renewed this long before Cognito says they expire so that a
+// request started just before expiry is not signed with a stale token.
+const tokenRefreshLeeway = 5 * time.Minute
+
+// authenticator makes the Cognito user pool calls TokenManager needs.
+type authenticator interface {
+	Login(ctx context.Context, login Login, device *RememberedDevice) (*types.AuthenticationResultType, error)
+	ResumeSMSLogin(ctx context.Context, login Login, device *RememberedDevice, pending PendingMFA) (*types.AuthenticationResultType, error)
+	Refresh(ctx context.Context, refreshToken, deviceKey string) (*types.AuthenticationResultType, error)
+	ConfirmDevice(ctx context.Context, accessToken string, metadata *types.NewDeviceMetadataType) (*RememberedDevice, error)
+}
+
+// userPool is the authenticator for a real Cognito user pool.
+type userPool struct {
+	endpoints config.Configuration
+}
+
+func (p userPool) Login(ctx context.Context, login Login, device *RememberedDevice) (*types.AuthenticationResultType, error) {
+	return AuthenticateWithUsernameAndPassword(ctx, p.endpoints, login, device)
+}
+
+func (p userPool) ResumeSMSLogin(ctx context.Context, login Login, device *RememberedDevice, pending PendingMFA) (*types.AuthenticationResultType, error) {
+	return ResumeSMSLogin(ctx, p.endpoints, login, device, pending)
+}
+
+func (p userPool) Refresh(ctx context.Context, refreshToken, deviceKey string) (*types.AuthenticationResultType, error) {
+	return RefreshAuthentication(ctx, p.endpoints, refreshToken, deviceKey)
+}
+
+func (p userPool) ConfirmDevice(ctx context.Context, accessToken string, metadata *types.NewDeviceMetadataType) (*RememberedDevice, error) {
+	return ConfirmDevice(ctx, p.endpoints, accessToken, metadata)
+}
+
+// TokenManager holds the Cognito user pool tokens for a single account and
+// keeps them fresh. Tokens are renewed with the refresh token ahead of expiry
+// and a full SRP login is only performed when there is no refresh token or
+// Cognito rejects it. The refresh token lives in the session store so that it
+// survives restarts.
+type TokenManager struct {
+	endpoints   config.Configuration
+	credentials Login
+	session     *SessionStore
+	auth        authenticator
+
+	mu          sync.Mutex
+	idToken     string
+	accessToken string
+	expiresAt   time.Time
+}
+
+func NewTokenManager(endpoints config.Configuration, login Login, session *SessionStore) *TokenManager {
+	return &TokenManager{
+		endpoints:   endpoints,
+		credentials: login,
+		session:     session,
+		auth:        userPool{endpoints: endpoints},
+	}
+}
+
+// Endpoints returns the Cognito configuration the tokens are issued by.
+func (tm *TokenManager) Endpoints() config.Configuration {
+	return tm.endpoints
+}
+
+// Session returns the store holding the persisted parts of the login.
+func (tm *TokenManager) Session() *SessionStore {
+	return tm.session
+}
+
+// IdToken returns a valid ID token, renewing it first if required.
+func (tm *TokenManager) IdToken(ctx context.Context) (string, error) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	if err := tm.ensureFresh(ctx); err != nil {
+		return "", err
+	}
+
+	return tm.idToken, nil
+}
+
+// Invalidate discards the current ID and access tokens so the next call
+// renews them, for when the API has rejected them before their expiry.
+func (tm *TokenManager) Invalidate() {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	tm.expiresAt = time.Time{}
+}
+
+func (tm *TokenManager) ensureFresh(ctx context.Context) error {
+	if tm.idToken != "" && time.Now().Add(tokenRefreshLeeway).Before(tm.expiresAt) {
+		return nil
+	}
+
+	session := tm.session.Get()
+
+	if session.RefreshToken != "" {
+		result, err := tm.auth.Refresh(ctx, session.RefreshToken, session.DeviceKey)
+
+		if err == nil {
+			log.Println("Refreshed Cognito tokens")
+			return tm.store(result)
+		}
+
+		if !isNotAuthorized(err) {
+			return err
+		}
+
+		log.Printf("Refresh token rejected, logging in with password: %s", err)
+		tm.session.Update(func(session *Session) {
+			session.RefreshToken = ""
+			session.IdentityID = ""
+		})
+	}
+
+	return tm.login(ctx, session.Device())
+}
+
+// login performs a password login, as a remembered device when there is one.
+// A device Cognito no longer recognises is forgotten and the login retried
+// without it, after which a new device is confirmed. An SMS MFA challenge left
+// open by the last login is answered with the configured code first.
+func (tm *TokenManager) login(ctx context.Context, device *RememberedDevice) error {
+	if pending := tm.session.Get().PendingMFA; pending != nil {
+		tm.session.Update(func(session *Session) {
+			session.PendingMFA = nil
+		})
+
+		if tm.credentials.SMSCode != "" && time.Now().Before(pending.Expires) {
+			result, err := tm.auth.ResumeSMSLogin(ctx, tm.credentials, device, *pending)
+
+			if err == nil {
+				log.Println("Logged in to Cognito with SMS MFA code")
+				return tm.loggedIn(ctx, result)
+			}
+
+			log.Printf("SMS MFA code rejected, logging in again: %s", err)
+		}
+	}
+
+	// The configured code was sent for an earlier login, so it cannot answer
+	// the challenge of this one
+	credentials := tm.credentials
+	credentials.SMSCode = ""
+
+	result, err := tm.auth.Login(ctx, credentials, device)
+
+	// A wrong password is also NotAuthorized, so the device is only dropped
+	// when Cognito does not know it or rejected the device challenges
+	if err != nil && device != nil && (isResourceNotFound(err) || isDeviceAuthError(err)) {
+		log.Printf("Remembered device rejected, logging in without it: %s", err)
+		tm.session.Update(func(session *Session) {
+			session.setDevice(nil)
+		})
+
+		return tm.login(ctx, nil)
+	}
+
+	var mfaRequired *MFARequiredError
+
+	if errors.As(err, &mfaRequired) && mfaRequired.Pending != nil {
+		tm.session.Update(func(session *Session) {
+			session.PendingMFA = mfaRequired.Pending
+		})
+	}
+
+	if isNotAuthorized(err) {
+		return &LoginRejectedError{Err: err}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	log.Println("Logged in to Cognito with password")
+
+	return tm.loggedIn(ctx, result)
+}
+
+// loggedIn stores the tokens from a completed login and confirms the device
+// Cognito asks to remember, if any.
+func (tm *TokenManager) loggedIn(ctx context.Context, result *types.AuthenticationResultType) error {
+	if err := tm.store(result); err != nil {
+		return err
+	}
+
+	if result.NewDeviceMetadata != nil {
+		confirmed, err := tm.auth.ConfirmDevice(ctx, tm.accessToken, result.NewDeviceMetadata)
+
+		if err != nil {
+			log.Printf("Failed to remember device, future logins will not use device SRP: %s", err)
+			return nil
+		}
+
+		log.Printf("Remembered device %s", confirmed.Key)
+		tm.session.Update(func(session *Session) {
+			session.setDevice(confirmed)
+		})
+	}
+
+	return nil
+}
+
+func (tm *TokenManager) store(result *types.AuthenticationResultType) error {
+	if result == nil || result.IdToken == nil || result.AccessToken == nil {
+		return fmt.Errorf("authentication result is missing tokens")
+	}
+
+	tm.idToken = *result.IdToken
+	tm.accessToken = *result.AccessToken
+	tm.expiresAt = time.Now().Add(time.Duration(result.ExpiresIn) * time.Second)
+
+	if result.RefreshToken != nil {
+		tm.session.Update(func(session *Session) {
+			session.RefreshToken = *result.RefreshToken
+		})
+	}
+
+	return nil
+}
+
+func isNotAuthorized(err error) bool {
+	var notAuthorized *types.NotAuthorizedException
+	return errors.As(err, &notAuthorized)
+}
+
+func isResourceNotFound(err error) bool {
+	var notFound *types.ResourceNotFoundException
+	return errors.As(err, &notFound)
+}
+
+func isDeviceAuthError(err error) bool {
+	var deviceAuth *DeviceAuthError
+	return errors.As(err, &deviceAuth)
+}
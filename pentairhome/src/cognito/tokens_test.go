@@ -0,0 +1,280 @@
+package cognito
+
+import (
+	"context"
+	"pentairhome/config"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+)
+
+// fakeAuthenticator returns canned results and counts the calls made.
+type fakeAuthenticator struct {
+	loginErr   error
+	refreshErr error
+	// deviceErr is returned instead of loginErr for logins as a device
+	deviceErr error
+
+	mu        sync.Mutex
+	logins    []*RememberedDevice
+	resumed   []PendingMFA
+	refreshes int
+}
+
+func authResult(idToken string, refreshToken string) *types.AuthenticationResultType {
+	result := &types.AuthenticationResultType{
+		IdToken:     aws.String(idToken),
+		AccessToken: aws.String("access-" + idToken),
+		ExpiresIn:   3600,
+	}
+
+	if refreshToken != "" {
+		result.RefreshToken = aws.String(refreshToken)
+	}
+
+	return result
+}
+
+func (f *fakeAuthenticator) Login(ctx context.Context, login Login, device *RememberedDevice) (*types.AuthenticationResultType, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.logins = append(f.logins, device)
+
+	if device != nil && f.deviceErr != nil {
+		return nil, f.deviceErr
+	}
+
+	if f.loginErr != nil {
+		return nil, f.loginErr
+	}
+
+	return authResult("login", "new-refresh"), nil
+}
+
+func (f *fakeAuthenticator) ResumeSMSLogin(ctx context.Context, login Login, device *RememberedDevice, pending PendingMFA) (*types.AuthenticationResultType, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.resumed = append(f.resumed, pending)
+
+	if login.SMSCode != "123456" {
+		return nil, &types.CodeMismatchException{}
+	}
+
+	return authResult("resumed", "resumed-refresh"), nil
+}
+
+func (f *fakeAuthenticator) Refresh(ctx context.Context, refreshToken, deviceKey string) (*types.AuthenticationResultType, error) {
+	f.mu.Lock()
+	f.refreshes++
+	f.mu.Unlock()
+
+	// Long enough for concurrent callers to pile up behind the first refresh
+	time.Sleep(10 * time.Millisecond)
+
+	if f.refreshErr != nil {
+		return nil, f.refreshErr
+	}
+
+	return authResult("refreshed", ""), nil
+}
+
+func (f *fakeAuthenticator) ConfirmDevice(ctx context.Context, accessToken string, metadata *types.NewDeviceMetadataType) (*RememberedDevice, error) {
+	return nil, nil
+}
+
+func testTokenManager(auth authenticator, refreshToken string) *TokenManager {
+	session := LoadSessionStore("", "user")
+	session.Update(func(session *Session) {
+		session.RefreshToken = refreshToken
+	})
+
+	tm := NewTokenManager(config.Configuration{}, Login{Username: "user", Password: "password"}, session)
+	tm.auth = auth
+
+	return tm
+}
+
+func TestTokenManagerRefresh(t *testing.T) {
+	auth := &fakeAuthenticator{}
+	tm := testTokenManager(auth, "saved-refresh")
+
+	idToken, err := tm.IdToken(context.Background())
+
+	if err != nil {
+		t.Fatalf("IdToken() error = %s", err)
+	}
+
+	if idToken != "refreshed" || auth.refreshes != 1 || len(auth.logins) != 0 {
+		t.Errorf("IdToken() = %s after %d refreshes and %d logins, want a single refresh", idToken, auth.refreshes, len(auth.logins))
+	}
+
+	if refreshToken := tm.Session().Get().RefreshToken; refreshToken != "saved-refresh" {
+		t.Errorf("refresh token = %s, want it kept", refreshToken)
+	}
+}
+
+func TestTokenManagerRefreshRejected(t *testing.T) {
+	auth := &fakeAuthenticator{refreshErr: &types.NotAuthorizedException{}}
+	tm := testTokenManager(auth, "expired-refresh")
+
+	idToken, err := tm.IdToken(context.Background())
+
+	if err != nil {
+		t.Fatalf("IdToken() error = %s", err)
+	}
+
+	if idToken != "login" || auth.refreshes != 1 || len(auth.logins) != 1 {
+		t.Errorf("IdToken() = %s after %d refreshes and %d logins, want a refresh then a login", idToken, auth.refreshes, len(auth.logins))
+	}
+
+	if refreshToken := tm.Session().Get().RefreshToken; refreshToken != "new-refresh" {
+		t.Errorf("refresh token = %s, want the one from the login", refreshToken)
+	}
+}
+
+func TestTokenManagerDeviceFallback(t *testing.T) {
+	tests := map[string]struct {
+		deviceErr    error
+		logins       int
+		keepsDevice  bool
+		wantsSuccess bool
+	}{
+		"device challenges rejected": {
+			deviceErr:    &DeviceAuthError{Err: &types.NotAuthorizedException{}},
+			logins:       2,
+			wantsSuccess: true,
+		},
+		"device unknown": {
+			deviceErr:    &types.ResourceNotFoundException{},
+			logins:       2,
+			wantsSuccess: true,
+		},
+		"wrong password": {
+			deviceErr:   &types.NotAuthorizedException{},
+			logins:      1,
+			keepsDevice: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			auth := &fakeAuthenticator{deviceErr: test.deviceErr}
+			tm := testTokenManager(auth, "")
+			tm.Session().Update(func(session *Session) {
+				session.setDevice(&RememberedDevice{Key: "device-key", GroupKey: "group-key", Password: "device-password"})
+			})
+
+			_, err := tm.IdToken(context.Background())
+
+			if (err == nil) != test.wantsSuccess {
+				t.Fatalf("IdToken() error = %v, want success %t", err, test.wantsSuccess)
+			}
+
+			if len(auth.logins) != test.logins {
+				t.Errorf("logged in %d times, want %d", len(auth.logins), test.logins)
+			}
+
+			if kept := tm.Session().Get().Device() != nil; kept != test.keepsDevice {
+				t.Errorf("device kept = %t, want %t", kept, test.keepsDevice)
+			}
+		})
+	}
+}
+
+func TestTokenManagerConcurrentRefresh(t *testing.T) {
+	auth := &fakeAuthenticator{}
+	tm := testTokenManager(auth, "saved-refresh")
+
+	var wg sync.WaitGroup
+
+	for range 5 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			if _, err := tm.IdToken(context.Background()); err != nil {
+				t.Errorf("IdToken() error = %s", err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if auth.refreshes != 1 {
+		t.Errorf("refreshed %d times, want 1", auth.refreshes)
+	}
+}
+
+func TestTokenManagerLoginRejected(t *testing.T) {
+	auth := &fakeAuthenticator{loginErr: &types.NotAuthorizedException{}}
+	tm := testTokenManager(auth, "")
+
+	_, err := tm.IdToken(context.Background())
+
+	if !IsFatal(err) {
+		t.Errorf("IdToken() error = %v, want a fatal error", err)
+	}
+}
+
+func TestTokenManagerSMSCode(t *testing.T) {
+	pending := &PendingMFA{Session: "session", UserID: "user-id", Expires: time.Now().Add(time.Minute)}
+
+	tests := []struct {
+		name    string
+		code    string
+		pending *PendingMFA
+		idToken string
+		resumes int
+		logins  int
+	}{
+		{"code for the pending challenge", "123456", pending, "resumed", 1, 0},
+		{"wrong code", "654321", pending, "", 1, 1},
+		{"challenge expired", "123456", &PendingMFA{Session: "session", Expires: time.Now().Add(-time.Minute)}, "", 0, 1},
+		{"no pending challenge", "123456", nil, "", 0, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// A fresh login sends a new code, leaving a new challenge pending
+			sent := &PendingMFA{Session: "new-session", UserID: "user-id", Expires: time.Now().Add(smsChallengeValidity)}
+			auth := &fakeAuthenticator{loginErr: &MFARequiredError{Challenge: types.ChallengeNameTypeSmsMfa, Pending: sent}}
+			tm := testTokenManager(auth, "")
+			tm.credentials.SMSCode = test.code
+			tm.Session().Update(func(session *Session) {
+				session.PendingMFA = test.pending
+			})
+
+			idToken, err := tm.IdToken(context.Background())
+
+			if len(auth.resumed) != test.resumes || len(auth.logins) != test.logins {
+				t.Errorf("resumed %d times and logged in %d times, want %d and %d", len(auth.resumed), len(auth.logins), test.resumes, test.logins)
+			}
+
+			if test.idToken != "" {
+				if err != nil || idToken != test.idToken {
+					t.Errorf("IdToken() = %s, %v, want %s", idToken, err, test.idToken)
+				}
+
+				if tm.Session().Get().PendingMFA != nil {
+					t.Error("expected the answered challenge to be forgotten")
+				}
+
+				return
+			}
+
+			if !IsFatal(err) {
+				t.Errorf("IdToken() error = %v, want a fatal error", err)
+			}
+
+			if kept := tm.Session().Get().PendingMFA; kept == nil || kept.Session != "new-session" {
+				t.Errorf("pending challenge = %+v, want the one from the new login", kept)
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package cognito
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	totpPeriod = 30
+	totpDigits = 6
+)
+
+// GenerateTOTP returns the RFC 6238 code for the given base32 secret at time
+// t, using the same parameters as Cognito software token MFA (SHA1, 30 second
+// period, 6 digits).
+func GenerateTOTP(secret string, t time.Time) (string, error) {
+	normalised := strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	normalised = strings.TrimRight(normalised, "=")
+
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(normalised)
+
+	if err != nil {
+		return "", fmt.Errorf("failed to decode totp secret: %s", err)
+	}
+
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(t.Unix()/totpPeriod))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", totpDigits, code%1000000), nil
+}
@@ -0,0 +1,49 @@
+package cognito
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGenerateTOTP(t *testing.T) {
+	// RFC 6238 SHA1 test vectors, truncated to six digits
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+	tests := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1111111111: "050471",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+
+	for unix, expected := range tests {
+		code, err := GenerateTOTP(secret, time.Unix(unix, 0))
+
+		if err != nil {
+			t.Fatalf("GenerateTOTP() error = %s", err)
+		}
+
+		if code != expected {
+			t.Errorf("GenerateTOTP(%d) = %s, want %s", unix, code, expected)
+		}
+	}
+}
+
+func TestGenerateTOTPNormalisesSecret(t *testing.T) {
+	code, err := GenerateTOTP("gezd gnbv gy3t qojq gezd gnbv gy3t qojq", time.Unix(59, 0))
+
+	if err != nil {
+		t.Fatalf("GenerateTOTP() error = %s", err)
+	}
+
+	if code != "287082" {
+		t.Errorf("GenerateTOTP() = %s, want 287082", code)
+	}
+}
+
+func TestGenerateTOTPInvalidSecret(t *testing.T) {
+	if _, err := GenerateTOTP("not base32!", time.Unix(59, 0)); err == nil {
+		t.Error("GenerateTOTP() expected an error for an invalid secret")
+	}
+}
@@ -3,15 +3,54 @@ package config
 import (
 	"flag"
 	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type RuntimeConfiguration struct {
-	PentairHomeUsername string
-	PentairHomePassword string
-	MQTTHost            string
-	MQTTPort            string
-	MQTTUsername        string
-	MQTTPassword        string
+	PentairHomeUsername    string
+	PentairHomePassword    string
+	PentairHomeTOTPSecret  string
+	PentairHomeMFACode     string
+	PentairHomeNewPassword string
+	MQTTHost               string
+	MQTTPort               string
+	MQTTUsername           string
+	MQTTPassword           string
+	MQTTClientID           string
+	MQTTQoS                int
+	MQTTRetainDiscovery    bool
+	MQTTRetainState        bool
+	MQTTBaseTopic          string
+	MQTTDiscoveryPrefix    string
+	MQTTScheme             string
+	MQTTPath               string
+	MQTTCAFile             string
+	MQTTCertFile           string
+	MQTTKeyFile            string
+	MQTTInsecureSkipVerify bool
+	ConfigDirectory        string
+	Endpoints              Configuration
+	RetryMaxAttempts       int
+	RetryBaseDelay         time.Duration
+	RetryMaxDelay          time.Duration
+	RetryJitter            float64
+	ExposeUnknownFields    bool
+	ExperimentalDevices    bool
+	ExperimentalControls   bool
+	PollInterval           time.Duration
+	PollIntervalFast       time.Duration
+	PollIntervalSlow       time.Duration
+	AdaptivePolling        bool
+	QuietHoursStart        int
+	QuietHoursEnd          int
+	UnitSystem             string
+	HeartbeatInterval      time.Duration
+	Deadbands              string
 }
 
 func (config *RuntimeConfiguration) ValidateRuntimeConfiguration() []error {
@@ -36,44 +75,324 @@ func (config *RuntimeConfiguration) ValidateRuntimeConfiguration() []error {
 		errors = append(errors, fmt.Errorf("MQTTPassword is required"))
 	}
 
+	if config.MQTTClientID == "" {
+		errors = append(errors, fmt.Errorf("MQTTClientID is required"))
+	}
+	if config.MQTTQoS < 0 || config.MQTTQoS > 2 {
+		errors = append(errors, fmt.Errorf("MQTTQoS must be 0, 1 or 2"))
+	}
+	if !isTopicPrefix(config.MQTTBaseTopic) {
+		errors = append(errors, fmt.Errorf("MQTTBaseTopic must be a topic without wildcards or leading or trailing slashes"))
+	}
+	if !isTopicPrefix(config.MQTTDiscoveryPrefix) {
+		errors = append(errors, fmt.Errorf("MQTTDiscoveryPrefix must be a topic without wildcards or leading or trailing slashes"))
+	}
+
+	errors = append(errors, config.validateMQTTTLS()...)
+
+	if config.RetryMaxAttempts < 1 {
+		errors = append(errors, fmt.Errorf("RetryMaxAttempts must be at least 1"))
+	}
+	if config.RetryBaseDelay < 0 || config.RetryMaxDelay < config.RetryBaseDelay {
+		errors = append(errors, fmt.Errorf("RetryMaxDelay must be at least RetryBaseDelay"))
+	}
+	if config.RetryJitter < 0 || config.RetryJitter > 1 {
+		errors = append(errors, fmt.Errorf("RetryJitter must be between 0 and 1"))
+	}
+
+	// The fast and slow intervals are only used with adaptive polling
+	if config.AdaptivePolling && (config.PollIntervalFast <= 0 || config.PollInterval < config.PollIntervalFast || config.PollIntervalSlow < config.PollInterval) {
+		errors = append(errors, fmt.Errorf("PollIntervalFast, PollInterval and PollIntervalSlow must be positive and in increasing order with adaptive polling"))
+	} else if config.PollInterval <= 0 {
+		errors = append(errors, fmt.Errorf("PollInterval must be positive"))
+	}
+	if config.QuietHoursStart < 0 || config.QuietHoursStart > 23 || config.QuietHoursEnd < 0 || config.QuietHoursEnd > 23 {
+		errors = append(errors, fmt.Errorf("QuietHoursStart and QuietHoursEnd must be hours between 0 and 23"))
+	}
+
+	if config.UnitSystem != "auto" && config.UnitSystem != "metric" && config.UnitSystem != "imperial" {
+		errors = append(errors, fmt.Errorf("UnitSystem must be auto, metric or imperial"))
+	}
+
+	if config.HeartbeatInterval < 0 {
+		errors = append(errors, fmt.Errorf("HeartbeatInterval must not be negative"))
+	}
+	if _, err := config.DeadbandsByClass(); err != nil {
+		errors = append(errors, err)
+	}
+
+	errors = append(errors, config.Endpoints.Validate()...)
+
 	return errors
 }
 
 func FetchRuntimeConfiguration() RuntimeConfiguration {
 	pentairHomeUsernamePtr := flag.String("pentairhome_username", "", "Pentair Home username")
 	pentairHomePasswordPtr := flag.String("pentairhome_password", "", "Pentair Home password")
+	pentairHomeTOTPSecretPtr := flag.String("pentairhome_totp_secret", "", "Pentair Home authenticator app MFA secret key")
+	pentairHomeMFACodePtr := flag.String("pentairhome_mfa_code", "", "Pentair Home SMS MFA code")
+	pentairHomeNewPasswordPtr := flag.String("pentairhome_new_password", "", "Pentair Home new password, used when a password reset is required")
 	mqttHostPtr := flag.String("mqtt_host", "", "MQTT host")
 	mqttPortPtr := flag.String("mqtt_port", "", "MQTT port")
 	mqttUsernamePtr := flag.String("mqtt_username", "", "MQTT username")
 	mqttPasswordPtr := flag.String("mqtt_password", "", "MQTT password")
+	mqttClientIDPtr := flag.String("mqtt_client_id", "pentairhome", "MQTT client ID, which must be unique on the broker")
+	mqttQoSPtr := flag.Int("mqtt_qos", 0, "MQTT QoS for discovery, state and subscriptions")
+	mqttRetainDiscoveryPtr := flag.Bool("mqtt_retain_discovery", false, "Retain discovery configs on the broker")
+	mqttRetainStatePtr := flag.Bool("mqtt_retain_state", false, "Retain device states on the broker")
+	mqttBaseTopicPtr := flag.String("mqtt_base_topic", "pentairhome", "Topic the add-on's state, availability and command topics start with")
+	mqttDiscoveryPrefixPtr := flag.String("mqtt_discovery_prefix", "homeassistant", "Home Assistant MQTT discovery prefix")
+	mqttSchemePtr := flag.String("mqtt_scheme", "mqtt", "MQTT connection scheme: mqtt, mqtts, ws or wss")
+	mqttPathPtr := flag.String("mqtt_path", "/mqtt", "Path of the MQTT websocket endpoint, for ws and wss")
+	mqttCAFilePtr := flag.String("mqtt_ca_file", "", "PEM CA bundle to verify the MQTT broker with, relative to config_dir")
+	mqttCertFilePtr := flag.String("mqtt_cert_file", "", "PEM client certificate for the MQTT broker, relative to config_dir")
+	mqttKeyFilePtr := flag.String("mqtt_key_file", "", "PEM private key of the MQTT client certificate, relative to config_dir")
+	mqttInsecureSkipVerifyPtr := flag.Bool("mqtt_insecure_skip_verify", false, "Accept any MQTT broker certificate, for testing only")
+	configDirectoryPtr := flag.String("config_dir", "/config", "Directory for state kept across restarts, empty to disable")
+	retryMaxAttemptsPtr := flag.Int("retry_max_attempts", 4, "Attempts made for each Pentair cloud request before giving up")
+	retryBaseDelayPtr := flag.Duration("retry_base_delay", time.Second, "Delay before the first retry of a Pentair cloud request")
+	retryMaxDelayPtr := flag.Duration("retry_max_delay", 30*time.Second, "Longest delay between retries of a Pentair cloud request")
+	retryJitterPtr := flag.Float64("retry_jitter", 0.2, "Fraction of the retry delay to randomly add or remove")
+	exposeUnknownFieldsPtr := flag.Bool("expose_unknown_fields", false, "Publish device fields missing from the field catalogue as diagnostic sensors")
+	experimentalDevicesPtr := flag.Bool("experimental_devices", false, "Add devices whose field maps have not been checked against a real device")
+	experimentalControlsPtr := flag.Bool("experimental_controls", false, "Make entities writable whose commands have not been checked against a real device")
+	pollIntervalPtr := flag.Duration("poll_interval", 60*time.Second, "Time between polls of the Pentair cloud")
+	pollIntervalFastPtr := flag.Duration("poll_interval_fast", 15*time.Second, "Time between polls after a command or while a pump runs, with adaptive polling")
+	pollIntervalSlowPtr := flag.Duration("poll_interval_slow", 5*time.Minute, "Time between polls while devices are offline or idle during quiet hours, with adaptive polling")
+	adaptivePollingPtr := flag.Bool("adaptive_polling", false, "Poll faster while devices are busy and slower while they are idle")
+	quietHoursStartPtr := flag.Int("quiet_hours_start", 22, "Hour quiet hours start, local time")
+	quietHoursEndPtr := flag.Int("quiet_hours_end", 6, "Hour quiet hours end, local time")
+	heartbeatIntervalPtr := flag.Duration("heartbeat_interval", 15*time.Minute, "Longest time between publishes of an unchanged device state, 0 to publish every update")
+	deadbandsPtr := flag.String("deadbands", "power=1,temperature=0.1,energy=0.01", "How far values of each device class may move before the state is published again, as class=amount pairs")
+	unitSystemPtr := flag.String("unit_system", "auto", "Units to publish readings in: auto to follow the Pentair account, metric or imperial")
+
+	defaults := FetchConfiguration()
+	awsRegionPtr := flag.String("aws_region", defaults.AWSRegion, "AWS region of the Pentair Cognito pools")
+	awsUserPoolIDPtr := flag.String("aws_user_pool_id", defaults.AWSUserPoolID, "Pentair Cognito user pool ID")
+	awsClientIDPtr := flag.String("aws_client_id", defaults.AWSClientID, "Pentair Cognito app client ID")
+	awsIdentityPoolIDPtr := flag.String("aws_identity_pool_id", defaults.AWSIdentityPoolId, "Pentair Cognito identity pool ID")
+	apiBaseURLPtr := flag.String("api_base_url", defaults.APIBaseURL, "Pentair cloud API base URL")
+	cognitoIDPEndpointPtr := flag.String("cognito_idp_endpoint", defaults.CognitoIDPEndpoint, "Cognito user pool endpoint, empty for the AWS default")
+	cognitoIdentityEndpointPtr := flag.String("cognito_identity_endpoint", defaults.CognitoIdentityEndpoint, "Cognito identity pool endpoint, empty for the AWS default")
+	iotEndpointPtr := flag.String("aws_iot_endpoint", defaults.IoTEndpoint, "AWS IoT endpoint to receive device shadow updates from, empty to only poll")
 	flag.Parse()
 
 	return RuntimeConfiguration{
-		PentairHomeUsername: *pentairHomeUsernamePtr,
-		PentairHomePassword: *pentairHomePasswordPtr,
-		MQTTHost:            *mqttHostPtr,
-		MQTTPort:            *mqttPortPtr,
-		MQTTUsername:        *mqttUsernamePtr,
-		MQTTPassword:        *mqttPasswordPtr,
+		PentairHomeUsername:    *pentairHomeUsernamePtr,
+		PentairHomePassword:    *pentairHomePasswordPtr,
+		PentairHomeTOTPSecret:  *pentairHomeTOTPSecretPtr,
+		PentairHomeMFACode:     *pentairHomeMFACodePtr,
+		PentairHomeNewPassword: *pentairHomeNewPasswordPtr,
+		MQTTHost:               *mqttHostPtr,
+		MQTTPort:               *mqttPortPtr,
+		MQTTUsername:           *mqttUsernamePtr,
+		MQTTPassword:           *mqttPasswordPtr,
+		MQTTClientID:           *mqttClientIDPtr,
+		MQTTQoS:                *mqttQoSPtr,
+		MQTTRetainDiscovery:    *mqttRetainDiscoveryPtr,
+		MQTTRetainState:        *mqttRetainStatePtr,
+		MQTTBaseTopic:          *mqttBaseTopicPtr,
+		MQTTDiscoveryPrefix:    *mqttDiscoveryPrefixPtr,
+		MQTTScheme:             *mqttSchemePtr,
+		MQTTPath:               *mqttPathPtr,
+		MQTTCAFile:             *mqttCAFilePtr,
+		MQTTCertFile:           *mqttCertFilePtr,
+		MQTTKeyFile:            *mqttKeyFilePtr,
+		MQTTInsecureSkipVerify: *mqttInsecureSkipVerifyPtr,
+		ConfigDirectory:        *configDirectoryPtr,
+		RetryMaxAttempts:       *retryMaxAttemptsPtr,
+		RetryBaseDelay:         *retryBaseDelayPtr,
+		RetryMaxDelay:          *retryMaxDelayPtr,
+		RetryJitter:            *retryJitterPtr,
+		ExposeUnknownFields:    *exposeUnknownFieldsPtr,
+		ExperimentalDevices:    *experimentalDevicesPtr,
+		ExperimentalControls:   *experimentalControlsPtr,
+		PollInterval:           *pollIntervalPtr,
+		PollIntervalFast:       *pollIntervalFastPtr,
+		PollIntervalSlow:       *pollIntervalSlowPtr,
+		AdaptivePolling:        *adaptivePollingPtr,
+		QuietHoursStart:        *quietHoursStartPtr,
+		QuietHoursEnd:          *quietHoursEndPtr,
+		UnitSystem:             *unitSystemPtr,
+		HeartbeatInterval:      *heartbeatIntervalPtr,
+		Deadbands:              *deadbandsPtr,
+		Endpoints: Configuration{
+			AWSRegion:               *awsRegionPtr,
+			AWSUserPoolID:           *awsUserPoolIDPtr,
+			AWSClientID:             *awsClientIDPtr,
+			AWSIdentityPoolId:       *awsIdentityPoolIDPtr,
+			APIBaseURL:              *apiBaseURLPtr,
+			CognitoIDPEndpoint:      *cognitoIDPEndpointPtr,
+			CognitoIdentityEndpoint: *cognitoIdentityEndpointPtr,
+			IoTEndpoint:             *iotEndpointPtr,
+		},
+	}
+}
+
+// DeadbandsByClass parses Deadbands, a comma separated list of device class
+// and amount pairs such as "power=1,temperature=0.1".
+func (config *RuntimeConfiguration) DeadbandsByClass() (map[string]float64, error) {
+	deadbands := map[string]float64{}
+
+	for _, pair := range strings.Split(config.Deadbands, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+
+		class, amount, ok := strings.Cut(pair, "=")
+		value, err := strconv.ParseFloat(strings.TrimSpace(amount), 64)
+
+		if !ok || err != nil || value < 0 {
+			return nil, fmt.Errorf("Deadbands must be device class and non-negative amount pairs such as power=1, got %q", pair)
+		}
+
+		deadbands[strings.TrimSpace(class)] = value
 	}
+
+	return deadbands, nil
 }
 
+// MQTTTLS reports whether the MQTT connection uses TLS.
+func (config *RuntimeConfiguration) MQTTTLS() bool {
+	return config.MQTTScheme == "mqtts" || config.MQTTScheme == "wss"
+}
+
+// ConfigFile resolves a path given in the configuration, which is relative to
+// ConfigDirectory unless it is absolute.
+func (config *RuntimeConfiguration) ConfigFile(path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+
+	return filepath.Join(config.ConfigDirectory, path)
+}
+
+func (config *RuntimeConfiguration) validateMQTTTLS() []error {
+	var errors []error
+
+	switch config.MQTTScheme {
+	case "mqtt", "ws":
+		if config.MQTTCAFile != "" || config.MQTTCertFile != "" || config.MQTTKeyFile != "" || config.MQTTInsecureSkipVerify {
+			errors = append(errors, fmt.Errorf("MQTT TLS options need the mqtts or wss MQTTScheme"))
+		}
+	case "mqtts", "wss":
+	default:
+		errors = append(errors, fmt.Errorf("MQTTScheme must be mqtt, mqtts, ws or wss"))
+	}
+
+	if (config.MQTTScheme == "ws" || config.MQTTScheme == "wss") && !strings.HasPrefix(config.MQTTPath, "/") {
+		errors = append(errors, fmt.Errorf("MQTTPath must start with /"))
+	}
+	if (config.MQTTCertFile == "") != (config.MQTTKeyFile == "") {
+		errors = append(errors, fmt.Errorf("MQTTCertFile and MQTTKeyFile must be set together"))
+	}
+
+	files := []struct{ name, path string }{
+		{"MQTTCAFile", config.MQTTCAFile},
+		{"MQTTCertFile", config.MQTTCertFile},
+		{"MQTTKeyFile", config.MQTTKeyFile},
+	}
+
+	for _, file := range files {
+		if file.path == "" {
+			continue
+		}
+
+		if _, err := os.Stat(config.ConfigFile(file.path)); err != nil {
+			errors = append(errors, fmt.Errorf("%s cannot be read: %s", file.name, err))
+		}
+	}
+
+	return errors
+}
+
+// SessionFile is where the Cognito session is saved, or empty when state is
+// not being kept.
+func (config *RuntimeConfiguration) SessionFile() string {
+	if config.ConfigDirectory == "" {
+		return ""
+	}
+
+	return filepath.Join(config.ConfigDirectory, "session.json")
+}
+
+// EnergyFile is where energy totals are saved, or empty when state is not
+// being kept.
+func (config *RuntimeConfiguration) EnergyFile() string {
+	if config.ConfigDirectory == "" {
+		return ""
+	}
+
+	return filepath.Join(config.ConfigDirectory, "energy.json")
+}
+
+// Configuration describes where the Pentair cloud lives. The defaults match
+// the Pentair Home app; every value can be overridden so the add-on can be
+// pointed at a stand-in server or follow Pentair moving its pools. IoTEndpoint
+// is optional and enables push updates from device shadows.
 type Configuration struct {
-	AWSRegion         string
-	AWSUserPoolID     string
-	AWSClientID       string
-	AWSIdentityPoolId string
+	AWSRegion               string
+	AWSUserPoolID           string
+	AWSClientID             string
+	AWSIdentityPoolId       string
+	APIBaseURL              string
+	CognitoIDPEndpoint      string
+	CognitoIdentityEndpoint string
+	IoTEndpoint             string
 }
 
 func (c Configuration) GetLoginKey() string {
 	return fmt.Sprintf("cognito-idp.%s.amazonaws.com/%s", c.AWSRegion, c.AWSUserPoolID)
 }
 
+func (c Configuration) Validate() []error {
+	var errors []error
+
+	if c.AWSRegion == "" {
+		errors = append(errors, fmt.Errorf("AWSRegion is required"))
+	}
+	if c.AWSUserPoolID == "" {
+		errors = append(errors, fmt.Errorf("AWSUserPoolID is required"))
+	}
+	if c.AWSClientID == "" {
+		errors = append(errors, fmt.Errorf("AWSClientID is required"))
+	}
+	if c.AWSIdentityPoolId == "" {
+		errors = append(errors, fmt.Errorf("AWSIdentityPoolId is required"))
+	}
+	if c.APIBaseURL == "" {
+		errors = append(errors, fmt.Errorf("APIBaseURL is required"))
+	} else if !isAbsoluteURL(c.APIBaseURL) {
+		errors = append(errors, fmt.Errorf("APIBaseURL must be an absolute URL"))
+	}
+	if c.CognitoIDPEndpoint != "" && !isAbsoluteURL(c.CognitoIDPEndpoint) {
+		errors = append(errors, fmt.Errorf("CognitoIDPEndpoint must be an absolute URL"))
+	}
+	if c.CognitoIdentityEndpoint != "" && !isAbsoluteURL(c.CognitoIdentityEndpoint) {
+		errors = append(errors, fmt.Errorf("CognitoIdentityEndpoint must be an absolute URL"))
+	}
+	if strings.ContainsAny(c.IoTEndpoint, ":/") {
+		errors = append(errors, fmt.Errorf("IoTEndpoint must be a host name without a scheme or port"))
+	}
+
+	return errors
+}
+
+func isTopicPrefix(value string) bool {
+	return value != "" && !strings.ContainsAny(value, "+#") && !strings.HasPrefix(value, "/") && !strings.HasSuffix(value, "/")
+}
+
+func isAbsoluteURL(value string) bool {
+	u, err := url.Parse(value)
+	return err == nil && u.Scheme != "" && u.Host != ""
+}
+
 func FetchConfiguration() *Configuration {
 	return &Configuration{
 		AWSRegion:         "us-west-2",
 		AWSUserPoolID:     "us-west-2_lbiduhSwD",
 		AWSClientID:       "3de110o697faq7avdchtf07h4v",
 		AWSIdentityPoolId: "us-west-2:6f950f85-af44-43d9-b690-a431f753e9aa",
+		APIBaseURL:        "https://api.pentair.cloud/",
 	}
 }
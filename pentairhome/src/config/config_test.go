@@ -2,8 +2,10 @@ package config
 
 import (
 	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestFetchRuntimeConfiguration(t *testing.T) {
@@ -12,23 +14,81 @@ func TestFetchRuntimeConfiguration(t *testing.T) {
 		"test",
 		"--pentairhome_username=testuser",
 		"--pentairhome_password=testpassword",
+		"--pentairhome_totp_secret=testsecret",
+		"--pentairhome_mfa_code=123456",
+		"--pentairhome_new_password=testnewpassword",
 		"--mqtt_host=testhost",
 		"--mqtt_port=testport",
 		"--mqtt_username=testusername",
 		"--mqtt_password=testpassword",
+		"--mqtt_client_id=pentairhome-test",
+		"--mqtt_qos=1",
+		"--mqtt_retain_discovery",
+		"--mqtt_base_topic=pool/pentair",
+		"--mqtt_scheme=mqtts",
+		"--mqtt_ca_file=ca.pem",
+		"--config_dir=/tmp/testconfig",
+		"--api_base_url=http://localhost:8080/",
+		"--cognito_idp_endpoint=http://localhost:8081",
+		"--retry_max_attempts=6",
+		"--aws_iot_endpoint=example-ats.iot.us-west-2.amazonaws.com",
+		"--expose_unknown_fields",
+		"--experimental_devices",
+		"--experimental_controls",
+		"--poll_interval=2m",
+		"--adaptive_polling",
+		"--unit_system=metric",
+		"--heartbeat_interval=30m",
 	}
 
 	// Call the function
 	config := FetchRuntimeConfiguration()
 
 	// Assert the expected values
-	expectedConfig := &RuntimeConfiguration{
-		PentairHomeUsername: "testuser",
-		PentairHomePassword: "testpassword",
-		MQTTHost:            "testhost",
-		MQTTPort:            "testport",
-		MQTTUsername:        "testusername",
-		MQTTPassword:        "testpassword",
+	expectedConfig := RuntimeConfiguration{
+		PentairHomeUsername:    "testuser",
+		PentairHomePassword:    "testpassword",
+		PentairHomeTOTPSecret:  "testsecret",
+		PentairHomeMFACode:     "123456",
+		PentairHomeNewPassword: "testnewpassword",
+		MQTTHost:               "testhost",
+		MQTTPort:               "testport",
+		MQTTUsername:           "testusername",
+		MQTTPassword:           "testpassword",
+		MQTTClientID:           "pentairhome-test",
+		MQTTQoS:                1,
+		MQTTRetainDiscovery:    true,
+		MQTTBaseTopic:          "pool/pentair",
+		MQTTDiscoveryPrefix:    "homeassistant",
+		MQTTScheme:             "mqtts",
+		MQTTPath:               "/mqtt",
+		MQTTCAFile:             "ca.pem",
+		ConfigDirectory:        "/tmp/testconfig",
+		RetryMaxAttempts:       6,
+		RetryBaseDelay:         time.Second,
+		RetryMaxDelay:          30 * time.Second,
+		RetryJitter:            0.2,
+		ExposeUnknownFields:    true,
+		ExperimentalDevices:    true,
+		ExperimentalControls:   true,
+		PollInterval:           2 * time.Minute,
+		PollIntervalFast:       15 * time.Second,
+		PollIntervalSlow:       5 * time.Minute,
+		AdaptivePolling:        true,
+		QuietHoursStart:        22,
+		QuietHoursEnd:          6,
+		UnitSystem:             "metric",
+		HeartbeatInterval:      30 * time.Minute,
+		Deadbands:              "power=1,temperature=0.1,energy=0.01",
+		Endpoints: Configuration{
+			AWSRegion:          "us-west-2",
+			AWSUserPoolID:      "us-west-2_lbiduhSwD",
+			AWSClientID:        "3de110o697faq7avdchtf07h4v",
+			AWSIdentityPoolId:  "us-west-2:6f950f85-af44-43d9-b690-a431f753e9aa",
+			APIBaseURL:         "http://localhost:8080/",
+			CognitoIDPEndpoint: "http://localhost:8081",
+			IoTEndpoint:        "example-ats.iot.us-west-2.amazonaws.com",
+		},
 	}
 
 	if !reflect.DeepEqual(config, expectedConfig) {
@@ -46,6 +106,7 @@ func TestFetchConfiguration(t *testing.T) {
 		AWSUserPoolID:     "us-west-2_lbiduhSwD",
 		AWSClientID:       "3de110o697faq7avdchtf07h4v",
 		AWSIdentityPoolId: "us-west-2:6f950f85-af44-43d9-b690-a431f753e9aa",
+		APIBaseURL:        "https://api.pentair.cloud/",
 	}
 
 	if !reflect.DeepEqual(config, expectedConfig) {
@@ -80,6 +141,24 @@ func getBaseConfig() *RuntimeConfiguration {
 		MQTTPort:            "MQTTPort",
 		MQTTUsername:        "MQTTUsername",
 		MQTTPassword:        "MQTTPassword",
+		MQTTClientID:        "pentairhome",
+		MQTTBaseTopic:       "pentairhome",
+		MQTTDiscoveryPrefix: "homeassistant",
+		MQTTScheme:          "mqtt",
+		MQTTPath:            "/mqtt",
+		Endpoints:           *FetchConfiguration(),
+		RetryMaxAttempts:    4,
+		RetryBaseDelay:      time.Second,
+		RetryMaxDelay:       30 * time.Second,
+		RetryJitter:         0.2,
+		PollInterval:        60 * time.Second,
+		PollIntervalFast:    15 * time.Second,
+		PollIntervalSlow:    5 * time.Minute,
+		QuietHoursStart:     22,
+		QuietHoursEnd:       6,
+		UnitSystem:          "auto",
+		HeartbeatInterval:   15 * time.Minute,
+		Deadbands:           "power=1,temperature=0.1,energy=0.01",
 	}
 }
 
@@ -101,3 +180,147 @@ func TestValidateRuntimeConfiguration(t *testing.T) {
 		}
 	}
 }
+
+func TestSessionFile(t *testing.T) {
+	config := getBaseConfig()
+
+	if sessionFile := config.SessionFile(); sessionFile != "" {
+		t.Errorf("SessionFile() = %s, want empty", sessionFile)
+	}
+
+	config.ConfigDirectory = "/config"
+
+	if sessionFile := config.SessionFile(); sessionFile != "/config/session.json" {
+		t.Errorf("SessionFile() = %s, want /config/session.json", sessionFile)
+	}
+
+	if energyFile := config.EnergyFile(); energyFile != "/config/energy.json" {
+		t.Errorf("EnergyFile() = %s, want /config/energy.json", energyFile)
+	}
+}
+
+func TestValidateEndpoints(t *testing.T) {
+	config := getBaseConfig()
+
+	if errors := config.ValidateRuntimeConfiguration(); len(errors) != 0 {
+		t.Errorf("ValidateRuntimeConfiguration() = %v, want no errors", errors)
+	}
+
+	config.Endpoints.APIBaseURL = "api.pentair.cloud"
+	config.Endpoints.CognitoIDPEndpoint = "localhost:8081"
+	config.Endpoints.CognitoIdentityEndpoint = "http://localhost:8082"
+	errors := config.ValidateRuntimeConfiguration()
+
+	if len(errors) != 2 {
+		t.Fatalf("ValidateRuntimeConfiguration() = %v, want 2 errors", errors)
+	}
+
+	if errors[0].Error() != "APIBaseURL must be an absolute URL" {
+		t.Errorf("ValidateRuntimeConfiguration() = %v, want APIBaseURL must be an absolute URL", errors[0])
+	}
+
+	if errors[1].Error() != "CognitoIDPEndpoint must be an absolute URL" {
+		t.Errorf("ValidateRuntimeConfiguration() = %v, want CognitoIDPEndpoint must be an absolute URL", errors[1])
+	}
+
+	config = getBaseConfig()
+	config.Endpoints.IoTEndpoint = "wss://example-ats.iot.us-west-2.amazonaws.com/mqtt"
+
+	if errors := config.ValidateRuntimeConfiguration(); len(errors) != 1 {
+		t.Errorf("ValidateRuntimeConfiguration() = %v, want 1 error", errors)
+	}
+}
+
+func TestValidatePolling(t *testing.T) {
+	config := getBaseConfig()
+	config.PollInterval = 10 * time.Second
+
+	if errors := config.ValidateRuntimeConfiguration(); len(errors) != 0 {
+		t.Errorf("ValidateRuntimeConfiguration() = %v, want no errors without adaptive polling", errors)
+	}
+
+	config.AdaptivePolling = true
+
+	if errors := config.ValidateRuntimeConfiguration(); len(errors) != 1 {
+		t.Errorf("ValidateRuntimeConfiguration() = %v, want 1 error", errors)
+	}
+
+	config = getBaseConfig()
+	config.QuietHoursEnd = 24
+
+	if errors := config.ValidateRuntimeConfiguration(); len(errors) != 1 {
+		t.Errorf("ValidateRuntimeConfiguration() = %v, want 1 error", errors)
+	}
+}
+
+func TestValidateUnitSystem(t *testing.T) {
+	config := getBaseConfig()
+	config.UnitSystem = "kelvin"
+
+	if errors := config.ValidateRuntimeConfiguration(); len(errors) != 1 {
+		t.Errorf("ValidateRuntimeConfiguration() = %v, want 1 error", errors)
+	}
+}
+
+func TestDeadbandsByClass(t *testing.T) {
+	config := getBaseConfig()
+	config.Deadbands = "power=1, temperature=0.1,"
+	deadbands, err := config.DeadbandsByClass()
+
+	if err != nil {
+		t.Fatalf("DeadbandsByClass() error = %s", err)
+	}
+
+	if expected := map[string]float64{"power": 1, "temperature": 0.1}; !reflect.DeepEqual(deadbands, expected) {
+		t.Errorf("DeadbandsByClass() = %v, want %v", deadbands, expected)
+	}
+
+	config.Deadbands = "power"
+
+	if errors := config.ValidateRuntimeConfiguration(); len(errors) != 1 {
+		t.Errorf("ValidateRuntimeConfiguration() = %v, want 1 error", errors)
+	}
+}
+
+func TestValidateMQTT(t *testing.T) {
+	config := getBaseConfig()
+	config.MQTTQoS = 3
+	config.MQTTBaseTopic = "pentairhome/#"
+	config.MQTTDiscoveryPrefix = "homeassistant/"
+
+	if errors := config.ValidateRuntimeConfiguration(); len(errors) != 3 {
+		t.Errorf("ValidateRuntimeConfiguration() = %v, want 3 errors", errors)
+	}
+}
+
+func TestValidateMQTTTLS(t *testing.T) {
+	config := getBaseConfig()
+	config.ConfigDirectory = t.TempDir()
+	config.MQTTCAFile = "ca.pem"
+
+	// TLS options without a TLS scheme, and a CA bundle that does not exist
+	if errors := config.ValidateRuntimeConfiguration(); len(errors) != 2 {
+		t.Errorf("ValidateRuntimeConfiguration() = %v, want 2 errors", errors)
+	}
+
+	if err := os.WriteFile(filepath.Join(config.ConfigDirectory, "ca.pem"), []byte{}, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	config.MQTTScheme = "mqtts"
+
+	if errors := config.ValidateRuntimeConfiguration(); len(errors) != 0 {
+		t.Errorf("ValidateRuntimeConfiguration() = %v, want no errors", errors)
+	}
+
+	config.MQTTCertFile = "/ssl/client.pem"
+
+	// A certificate without a key, and a certificate that does not exist
+	if errors := config.ValidateRuntimeConfiguration(); len(errors) != 2 {
+		t.Errorf("ValidateRuntimeConfiguration() = %v, want 2 errors", errors)
+	}
+
+	if path := config.ConfigFile(config.MQTTCertFile); path != "/ssl/client.pem" {
+		t.Errorf("ConfigFile() = %s, want /ssl/client.pem", path)
+	}
+}
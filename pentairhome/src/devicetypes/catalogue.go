@@ -0,0 +1,107 @@
+package devicetypes
+
+import (
+	"pentairhome/pentaircloud"
+	"regexp"
+	"sort"
+)
+
+// catalogue describes field codes that mean the same thing on most products
+// that report them, by field code. It fills in fields a device's family does
+// not declare, using the Key the pump families declare them under so an
+// entity keeps its unique ID if its device's family starts declaring it.
+var catalogue = map[string]Entity{
+	"t0":   {Key: "actualtemp", Name: "Water Temperature", Component: Sensor, DeviceClass: "temperature", Unit: "°F"},
+	"t1":   {Key: "outsidetemp", Name: "Outside Temperature", Component: Sensor, DeviceClass: "temperature", Unit: "°F"},
+	"ifs3": {Key: "power", Name: "Pump Power", Component: Sensor, DeviceClass: "power", Unit: "W"},
+	"ifs4": {Key: "actualspeed", Name: "Pump Speed", Component: Sensor, DeviceClass: "speed", Unit: "rpm"},
+	"ifs6": {Key: "actualflow", Name: "Pump Flow", Component: Sensor, DeviceClass: "volume_flow_rate", Unit: "gal/min"},
+	"rssi": {Key: "rssi", Name: "Wi-Fi Signal", Component: Sensor, DeviceClass: "signal_strength", Unit: "dBm", Diagnostic: true},
+}
+
+// statusEntities are published for every device, whatever its family.
+var statusEntities = []Entity{
+	{Key: "online", Name: "Online", Component: BinarySensor, DeviceClass: "connectivity", Diagnostic: true, Status: func(device *pentaircloud.Device) any {
+		return device.Online
+	}},
+	{Key: "alarm", Name: "Alarm", Component: BinarySensor, DeviceClass: "problem", Status: func(device *pentaircloud.Device) any {
+		return device.Alarm
+	}},
+	{Key: "deb_off_stat", Name: "Debounce Off", Component: BinarySensor, Diagnostic: true, Status: func(device *pentaircloud.Device) any {
+		return device.DebOffStat
+	}},
+}
+
+// Field codes end up in MQTT topics and value templates, so anything unusual
+// is left out rather than escaped.
+var validFieldCode = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// Entities returns every entity to publish for device: its status flags, its
+// family's entities, then its relays and catalogued fields the family does not
+// cover and, with exposeUnknown, every remaining field as a diagnostic text
+// sensor. Only fields the device reports are included.
+func Entities(device *pentaircloud.Device, exposeUnknown bool) []Entity {
+	entities := append([]Entity(nil), statusEntities...)
+	covered := map[string]bool{}
+	family, hasFamily := ForDevice(device)
+
+	if hasFamily {
+		for _, entity := range family.Entities {
+			covered[entity.Field] = true
+
+			if entity.Heater != nil {
+				covered[entity.Heater.ModeField] = true
+			}
+
+			if _, ok := device.Fields[entity.Field]; ok {
+				entities = append(entities, entity)
+			}
+		}
+	}
+
+	codes := make([]string, 0, len(device.Fields))
+
+	for code := range device.Fields {
+		if !covered[code] && validFieldCode.MatchString(code) {
+			codes = append(codes, code)
+		}
+	}
+
+	sort.Strings(codes)
+
+	for _, code := range codes {
+		entity, known := catalogue[code]
+
+		if hasFamily && family.Relays != nil && family.Relays.MatchString(code) {
+			entity, known = relayEntity(code, device.Fields[code]), true
+		}
+
+		if !known {
+			if !exposeUnknown {
+				continue
+			}
+
+			entity = Entity{Key: unknownFieldKey(code), Name: unknownFieldName(code, device.Fields[code]), Component: Sensor, Diagnostic: true, Text: true}
+		}
+
+		entity.Field = code
+		entities = append(entities, entity)
+	}
+
+	return entities
+}
+
+// unknownFieldKey prefixes the code of an unknown field, so that a field
+// named like a status entity or another value in the state payload, such as
+// online or energy, cannot take its unique ID or state.
+func unknownFieldKey(code string) string {
+	return "field_" + code
+}
+
+func unknownFieldName(code string, field pentaircloud.DeviceField) string {
+	if field.Name != "" && field.Name != code {
+		return field.Name + " (" + code + ")"
+	}
+
+	return "Field " + code
+}
@@ -0,0 +1,313 @@
+// Package devicetypes describes the Pentair product families the add-on knows
+// and which of their fields become Home Assistant entities. Only the
+// IntelliConnect sensor fields have been checked against a real device; entities
+// whose field a device does not report are skipped rather than published
+// empty.
+package devicetypes
+
+import (
+	"fmt"
+	"pentairhome/pentaircloud"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Component is the Home Assistant MQTT platform an entity is published as.
+type Component string
+
+const (
+	Sensor       Component = "sensor"
+	BinarySensor Component = "binary_sensor"
+	Number       Component = "number"
+	Climate      Component = "climate"
+	Switch       Component = "switch"
+	Light        Component = "light"
+)
+
+// Entity maps one Pentair device field to a Home Assistant entity.
+type Entity struct {
+	// Key names the value in the device's state payload and ends its unique
+	// ID, so it must not change once released
+	Key         string
+	Name        string
+	Field       string
+	Component   Component
+	DeviceClass string
+	Unit        string
+	Diagnostic  bool
+	// Text publishes the value as reported instead of parsing it as a number
+	Text       bool
+	StateClass string
+	// Min and Max bound the values a Number or a Climate's set point accepts
+	// when the device does not report bounds for its field
+	Min  float64
+	Max  float64
+	Step float64
+	// Heater is required for Climate entities
+	Heater *Heater
+	// Status reads the device's own flags rather than a field. Status
+	// entities stay available while the device is offline so they can
+	// report it
+	Status func(device *pentaircloud.Device) any
+}
+
+// NumericStateClass is the state class of the entity's readings for Home
+// Assistant's long-term statistics, or empty if it has no numeric readings.
+// Numeric sensors are measurements unless they declare otherwise.
+func (e Entity) NumericStateClass() string {
+	if e.Component != Sensor || e.Text {
+		return ""
+	}
+
+	if e.StateClass != "" {
+		return e.StateClass
+	}
+
+	return "measurement"
+}
+
+// Writable reports whether the entity accepts commands.
+func (e Entity) Writable() bool {
+	return e.Component == Number || e.Component == Climate || e.isRelay()
+}
+
+func (e Entity) isRelay() bool {
+	return e.Component == Switch || e.Component == Light
+}
+
+// ReadOnly returns the entity as it is published while experimental controls
+// are turned off: a number becomes a sensor, a climate a sensor showing its
+// set point and a relay a binary sensor showing whether its circuit is on.
+func (e Entity) ReadOnly() Entity {
+	switch e.Component {
+	case Number:
+		e.Component = Sensor
+	case Climate:
+		e.Component, e.Name, e.DeviceClass, e.Heater = Sensor, e.Name+" Set Point", "temperature", nil
+	case Switch, Light:
+		e.Component, e.DeviceClass = BinarySensor, "power"
+	}
+
+	return e
+}
+
+// Bounds returns the range of values the entity's field accepts, preferring
+// the bounds the device reports over the entity's defaults.
+func (e Entity) Bounds(device *pentaircloud.Device) (float64, float64) {
+	minimum, maximum := e.Min, e.Max
+	field := device.Fields[e.Field]
+
+	if value, err := strconv.ParseFloat(field.Min, 64); err == nil {
+		minimum = value
+	}
+
+	if value, err := strconv.ParseFloat(field.Max, 64); err == nil {
+		maximum = value
+	}
+
+	return minimum, maximum
+}
+
+// ParseCommand checks a command payload for the entity and returns the fields
+// to set on the device. command names what is being set for entities that
+// take more than one kind of command, and is empty for the entity's own value.
+func (e Entity) ParseCommand(device *pentaircloud.Device, command, payload string) (map[string]string, error) {
+	if !e.Writable() {
+		return nil, fmt.Errorf("%s does not accept commands", e.Key)
+	}
+
+	if e.Component == Climate && command != "" {
+		return e.Heater.parseCommand(command, payload)
+	}
+
+	if command != "" {
+		return nil, fmt.Errorf("%s does not accept %s commands", e.Key, command)
+	}
+
+	if e.isRelay() {
+		return e.parseRelayCommand(payload)
+	}
+
+	value, err := e.parseNumber(device, payload)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{e.Field: value}, nil
+}
+
+func (e Entity) parseNumber(device *pentaircloud.Device, payload string) (string, error) {
+	value, err := strconv.ParseFloat(strings.TrimSpace(payload), 64)
+
+	if err != nil {
+		return "", fmt.Errorf("invalid value %q for %s: %s", payload, e.Key, err)
+	}
+
+	minimum, maximum := e.Bounds(device)
+
+	if value < minimum || value > maximum {
+		return "", fmt.Errorf("value %s for %s is outside %s to %s", payload, e.Key, formatNumber(minimum), formatNumber(maximum))
+	}
+
+	return formatNumber(value), nil
+}
+
+func parseFlag(value string) bool {
+	value = strings.ToLower(strings.TrimSpace(value))
+	return value != "" && value != "0" && value != "false" && value != "off"
+}
+
+func formatNumber(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// Value reads the entity's field from device. Sensors are parsed as numbers,
+// binary sensors and relays as on/off flags and climates as a HeaterState. The second
+// result is false when the device does not report the field.
+func (e Entity) Value(device *pentaircloud.Device) (any, bool, error) {
+	if e.Status != nil {
+		return e.Status(device), true, nil
+	}
+
+	field, ok := device.Fields[e.Field]
+
+	if !ok {
+		return nil, false, nil
+	}
+
+	if e.Component == BinarySensor || e.isRelay() {
+		return parseFlag(field.Value), true, nil
+	}
+
+	if e.Component == Climate {
+		state, err := e.Heater.state(device, e.Field)
+		return state, true, err
+	}
+
+	if e.Text {
+		return field.Value, true, nil
+	}
+
+	value, err := strconv.ParseFloat(field.Value, 64)
+
+	if err != nil {
+		return nil, true, fmt.Errorf("failed to parse %s (%s): %s", e.Key, e.Field, err)
+	}
+
+	return value, true, nil
+}
+
+// Family describes a Pentair product line: how to recognise its devices and
+// which entities they expose. Each family lives in its own file and registers
+// itself from init.
+type Family struct {
+	Name     string
+	Models   []string
+	Entities []Entity
+	// Relays matches the codes of relay fields, which become switches or
+	// lights named after their circuit
+	Relays *regexp.Regexp
+	// Schedule is set for pumps that run on programs stored in the cloud
+	Schedule *PumpSchedule
+	// Experimental families have not been checked against a real device
+	Experimental bool
+}
+
+// PumpSchedule gives the speeds a pump's schedule programs may use when the
+// pump does not report the range of its SpeedField.
+type PumpSchedule struct {
+	SpeedField string
+	MinSpeed   int
+	MaxSpeed   int
+}
+
+// Speeds returns the range of speeds programs may use on device, preferring
+// the bounds it reports for SpeedField.
+func (s PumpSchedule) Speeds(device *pentaircloud.Device) (int, int) {
+	minimum, maximum := Entity{Field: s.SpeedField, Min: float64(s.MinSpeed), Max: float64(s.MaxSpeed)}.Bounds(device)
+	return int(minimum), int(maximum)
+}
+
+func (f Family) matches(model string) bool {
+	for _, m := range f.Models {
+		if strings.EqualFold(m, model) {
+			return true
+		}
+	}
+
+	return false
+}
+
+var (
+	mu       sync.RWMutex
+	families []Family
+)
+
+// Register adds a family to the registry. It panics if a family with the same
+// name is already registered, as that can only be a programming error.
+func Register(family Family) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, registered := range families {
+		if registered.Name == family.Name {
+			panic(fmt.Sprintf("device family %s registered twice", family.Name))
+		}
+	}
+
+	families = append(families, family)
+}
+
+// Lookup finds the family for a device by its product model.
+func Lookup(model string) (Family, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	for _, family := range families {
+		if family.matches(model) {
+			return family, true
+		}
+	}
+
+	return Family{}, false
+}
+
+// ForDevice finds the family for device.
+func ForDevice(device *pentaircloud.Device) (Family, bool) {
+	return Lookup(device.ProductInfo.Model)
+}
+
+// Active reports whether device is doing something worth watching closely,
+// such as a pump running: any power or speed reading above zero, or a running
+// flag set.
+func Active(device *pentaircloud.Device) bool {
+	family, ok := ForDevice(device)
+
+	if !ok {
+		return false
+	}
+
+	for _, entity := range family.Entities {
+		value, ok, err := entity.Value(device)
+
+		if !ok || err != nil {
+			continue
+		}
+
+		switch entity.DeviceClass {
+		case "power", "speed":
+			if number, isNumber := value.(float64); isNumber && number > 0 {
+				return true
+			}
+		case "running":
+			if running, isFlag := value.(bool); isFlag && running {
+				return true
+			}
+		}
+	}
+
+	return false
+}
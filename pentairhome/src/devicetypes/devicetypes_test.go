@@ -0,0 +1,308 @@
+package devicetypes
+
+import (
+	"pentairhome/pentaircloud"
+	"reflect"
+	"testing"
+)
+
+func TestLookup(t *testing.T) {
+	tests := []struct {
+		model  string
+		family string
+		found  bool
+	}{
+		{"IntelliConnect", "IntelliConnect", true},
+		{"intelliconnect", "IntelliConnect", true},
+		{"IntelliChlor IC40", "IntelliChlor", true},
+		{"Unknown", "", false},
+	}
+
+	for _, test := range tests {
+		family, found := Lookup(test.model)
+
+		if found != test.found || family.Name != test.family {
+			t.Errorf("Lookup(%q) = %q, %t, want %q, %t", test.model, family.Name, found, test.family, test.found)
+		}
+	}
+}
+
+func TestEntityValue(t *testing.T) {
+	device := &pentaircloud.Device{
+		Fields: map[string]pentaircloud.DeviceField{
+			"ifs3": {Value: "512"},
+			"ifs1": {Value: "1"},
+			"bad":  {Value: "n/a"},
+		},
+	}
+
+	value, ok, err := Entity{Field: "ifs3", Component: Sensor}.Value(device)
+	if err != nil || !ok || value != 512.0 {
+		t.Errorf("sensor value = %v, %t, %v, want 512, true, nil", value, ok, err)
+	}
+
+	value, ok, err = Entity{Field: "ifs1", Component: BinarySensor}.Value(device)
+	if err != nil || !ok || value != true {
+		t.Errorf("binary sensor value = %v, %t, %v, want true, true, nil", value, ok, err)
+	}
+
+	if _, ok, _ := (Entity{Field: "t9", Component: Sensor}).Value(device); ok {
+		t.Error("expected a missing field to be skipped")
+	}
+
+	if _, _, err := (Entity{Field: "bad", Component: Sensor}).Value(device); err == nil {
+		t.Error("expected an error for a non-numeric sensor value")
+	}
+}
+
+func TestEntities(t *testing.T) {
+	device := &pentaircloud.Device{
+		ProductInfo: pentaircloud.ProductInfo{Model: "IntelliConnect"},
+		Fields: map[string]pentaircloud.DeviceField{
+			"ifs3":  {Value: "512"},
+			"rssi":  {Value: "-60"},
+			"zz9":   {Name: "Mystery", Value: "abc"},
+			"a.b":   {Value: "1"},
+			"alarm": {Value: "1"},
+		},
+	}
+
+	keys := func(entities []Entity) []string {
+		var keys []string
+		for _, entity := range entities {
+			keys = append(keys, entity.Key)
+		}
+		return keys
+	}
+
+	if got := keys(Entities(device, false)); !reflect.DeepEqual(got, []string{"online", "alarm", "deb_off_stat", "power", "rssi"}) {
+		t.Errorf("Entities() = %v, want [online alarm deb_off_stat power rssi]", got)
+	}
+
+	entities := Entities(device, true)
+
+	if got := keys(entities); !reflect.DeepEqual(got, []string{"online", "alarm", "deb_off_stat", "power", "field_alarm", "rssi", "field_zz9"}) {
+		t.Fatalf("Entities() with unknown fields = %v, want [online alarm deb_off_stat power field_alarm rssi field_zz9]", got)
+	}
+
+	unknown := entities[6]
+	if !unknown.Diagnostic || !unknown.Text || unknown.Name != "Mystery (zz9)" {
+		t.Errorf("unknown field entity = %+v", unknown)
+	}
+
+	// Catalogued fields share the families' keys, so unique IDs do not depend
+	// on whether the family is known
+	device.ProductInfo.Model = "Unknown"
+
+	if got := keys(Entities(device, false)); !reflect.DeepEqual(got, []string{"online", "alarm", "deb_off_stat", "power", "rssi"}) {
+		t.Errorf("Entities() without a family = %v, want [online alarm deb_off_stat power rssi]", got)
+	}
+}
+
+func TestCatalogueKeysMatchFamilies(t *testing.T) {
+	for _, family := range families {
+		for _, entity := range family.Entities {
+			// A family may use a code for something else, under another name
+			if catalogued, ok := catalogue[entity.Field]; ok && catalogued.Name == entity.Name && catalogued.Key != entity.Key {
+				t.Errorf("%s declares %s as %s, but the catalogue calls it %s", family.Name, entity.Field, entity.Key, catalogued.Key)
+			}
+		}
+	}
+}
+
+func TestParseCommand(t *testing.T) {
+	entity := Entity{Key: "targetspeed", Field: "ifs5", Component: Number, Min: 450, Max: 3450}
+	device := &pentaircloud.Device{
+		Fields: map[string]pentaircloud.DeviceField{
+			"ifs5": {Min: "1000", Max: "3000", Value: "1500"},
+		},
+	}
+
+	tests := []struct {
+		payload string
+		want    string
+		valid   bool
+	}{
+		{"2500", "2500", true},
+		{"2500.0", "2500", true},
+		{"1000", "1000", true},
+		{"900", "", false},
+		{"3450", "", false},
+		{"fast", "", false},
+	}
+
+	for _, test := range tests {
+		fields, err := entity.ParseCommand(device, "", test.payload)
+
+		if (err == nil) != test.valid || fields["ifs5"] != test.want {
+			t.Errorf("ParseCommand(%q) = %v, %v, want %q, valid %t", test.payload, fields, err, test.want, test.valid)
+		}
+	}
+
+	if _, err := (Entity{Key: "power", Component: Sensor}).ParseCommand(device, "", "1"); err == nil {
+		t.Error("expected a sensor to reject commands")
+	}
+}
+
+func TestHeater(t *testing.T) {
+	entity := Entity{Key: "heater", Field: "sp", Component: Climate, Min: 40, Max: 104, Heater: &Heater{
+		CurrentField: "t0",
+		ModeField:    "hm",
+		Off:          "0",
+		Presets:      []HeatSource{{Name: "Heater", Value: "1"}, {Name: "Solar Only", Value: "3"}},
+	}}
+	device := &pentaircloud.Device{
+		Fields: map[string]pentaircloud.DeviceField{
+			"sp": {Value: "84"},
+			"t0": {Value: "78.5"},
+			"hm": {Value: "3"},
+		},
+	}
+
+	value, ok, err := entity.Value(device)
+	state, _ := value.(HeaterState)
+
+	if err != nil || !ok || state.SetPoint != 84 || state.Current == nil || *state.Current != 78.5 || state.Mode != "heat" || state.Preset != "Solar Only" {
+		t.Errorf("Value() = %+v, %t, %v", value, ok, err)
+	}
+
+	commands := []struct {
+		setting string
+		payload string
+		want    map[string]string
+	}{
+		{"", "90", map[string]string{"sp": "90"}},
+		{"", "120", nil},
+		{"mode", "off", map[string]string{"hm": "0"}},
+		{"mode", "heat", map[string]string{"hm": "1"}},
+		{"mode", "cool", nil},
+		{"preset", "Solar Only", map[string]string{"hm": "3"}},
+		{"preset", "Gas", nil},
+	}
+
+	for _, command := range commands {
+		fields, err := entity.ParseCommand(device, command.setting, command.payload)
+
+		if (err == nil) != (command.want != nil) || !reflect.DeepEqual(fields, command.want) {
+			t.Errorf("ParseCommand(%q, %q) = %v, %v, want %v", command.setting, command.payload, fields, err, command.want)
+		}
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	heater := Entity{Key: "heater", Name: "Heater", Field: "s13", Component: Climate, Unit: "°F", Heater: &Heater{ModeField: "s12"}}
+	readOnly := heater.ReadOnly()
+
+	if readOnly.Component != Sensor || readOnly.Name != "Heater Set Point" || readOnly.DeviceClass != "temperature" || readOnly.Writable() {
+		t.Errorf("ReadOnly() = %+v, want a read only temperature sensor", readOnly)
+	}
+
+	device := &pentaircloud.Device{Fields: map[string]pentaircloud.DeviceField{"s13": {Value: "84"}}}
+
+	if value, ok, err := readOnly.Value(device); err != nil || !ok || value != 84.0 {
+		t.Errorf("read only heater value = %v, %t, %v, want 84", value, ok, err)
+	}
+
+	if speed := (Entity{Key: "targetspeed", Name: "Pump Target Speed", Component: Number, Unit: "rpm"}).ReadOnly(); speed.Component != Sensor || speed.Name != "Pump Target Speed" || speed.Unit != "rpm" {
+		t.Errorf("ReadOnly() = %+v, want a sensor", speed)
+	}
+
+	if sensor := (Entity{Key: "power", Name: "Pump Power", Component: Sensor}).ReadOnly(); sensor.Component != Sensor || sensor.Name != "Pump Power" {
+		t.Errorf("ReadOnly() = %+v, want the sensor unchanged", sensor)
+	}
+}
+
+func TestRelays(t *testing.T) {
+	device := &pentaircloud.Device{
+		ProductInfo: pentaircloud.ProductInfo{Model: "IntelliConnect"},
+		Fields: map[string]pentaircloud.DeviceField{
+			"r1": {Name: "Pool Light", Value: "1"},
+			"r2": {Name: "Waterfall", Value: "0"},
+			"r3": {Value: "0"},
+		},
+	}
+
+	entities := Entities(device, false)[len(statusEntities):]
+	want := []struct {
+		name      string
+		component Component
+	}{
+		{"Pool Light", Light},
+		{"Waterfall", Switch},
+		{"Relay 3", Switch},
+	}
+
+	if len(entities) != len(want) {
+		t.Fatalf("Entities() = %+v, want %d relays", entities, len(want))
+	}
+
+	for i, w := range want {
+		if entities[i].Name != w.name || entities[i].Component != w.component {
+			t.Errorf("relay %d = %s %s, want %s %s", i, entities[i].Component, entities[i].Name, w.component, w.name)
+		}
+	}
+
+	if value, _, _ := entities[0].Value(device); value != true {
+		t.Errorf("relay value = %v, want true", value)
+	}
+
+	if fields, err := entities[1].ParseCommand(device, "", "ON"); err != nil || fields["r2"] != "1" {
+		t.Errorf("ParseCommand(ON) = %v, %v", fields, err)
+	}
+
+	if _, err := entities[1].ParseCommand(device, "", "toggle"); err == nil {
+		t.Error("expected an invalid relay payload to be rejected")
+	}
+
+	readOnly := entities[0].ReadOnly()
+
+	if readOnly.Component != BinarySensor || readOnly.DeviceClass != "power" || readOnly.Writable() {
+		t.Errorf("read only relay = %+v, want a power binary sensor", readOnly)
+	}
+}
+
+func TestPumpScheduleSpeeds(t *testing.T) {
+	schedule := PumpSchedule{SpeedField: "ifs5", MinSpeed: 450, MaxSpeed: 3450}
+	device := &pentaircloud.Device{Fields: map[string]pentaircloud.DeviceField{
+		"ifs5": {Value: "2000", Min: "1100", Max: "3000"},
+	}}
+
+	if minimum, maximum := schedule.Speeds(device); minimum != 1100 || maximum != 3000 {
+		t.Errorf("Speeds() = %d, %d, want the reported 1100, 3000", minimum, maximum)
+	}
+
+	if minimum, maximum := schedule.Speeds(&pentaircloud.Device{}); minimum != 450 || maximum != 3450 {
+		t.Errorf("Speeds() = %d, %d, want the defaults 450, 3450", minimum, maximum)
+	}
+}
+
+func TestStatusEntities(t *testing.T) {
+	device := &pentaircloud.Device{Online: true, Alarm: true}
+	want := map[string]bool{"online": true, "alarm": true, "deb_off_stat": false}
+
+	for _, entity := range Entities(device, false) {
+		value, ok, err := entity.Value(device)
+
+		if err != nil || !ok || value != want[entity.Key] {
+			t.Errorf("%s = %v, %t, %v, want %t", entity.Key, value, ok, err, want[entity.Key])
+		}
+	}
+}
+
+func TestNumericStateClass(t *testing.T) {
+	tests := []struct {
+		entity   Entity
+		expected string
+	}{
+		{Entity{Component: Sensor}, "measurement"},
+		{Entity{Component: Sensor, StateClass: "total"}, "total"},
+		{Entity{Component: Sensor, Text: true}, ""},
+		{Entity{Component: BinarySensor}, ""},
+	}
+
+	for _, test := range tests {
+		if got := test.entity.NumericStateClass(); got != test.expected {
+			t.Errorf("expected state class %q for %+v, got %q", test.expected, test.entity, got)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package devicetypes
+
+import (
+	"fmt"
+	"pentairhome/pentaircloud"
+	"strconv"
+)
+
+// Heater describes the fields behind a Climate entity. The entity's Field is
+// the set point, CurrentField the measured temperature and ModeField the heat
+// source. Off is the ModeField value that turns heating off and each preset
+// is a heat source Home Assistant can pick; the first is used when heating is
+// switched on without choosing one.
+type Heater struct {
+	CurrentField string
+	ModeField    string
+	Off          string
+	Presets      []HeatSource
+}
+
+type HeatSource struct {
+	Name  string
+	Value string
+}
+
+// HeaterState is a Climate entity's value in the state payload. Mode is the
+// Home Assistant HVAC mode, "heat" or "off".
+type HeaterState struct {
+	Current  *float64 `json:"current,omitempty"`
+	SetPoint float64  `json:"setpoint"`
+	Mode     string   `json:"mode"`
+	Preset   string   `json:"preset"`
+}
+
+// PresetNames lists the heat sources in the order Home Assistant offers them.
+func (h *Heater) PresetNames() []string {
+	names := make([]string, 0, len(h.Presets))
+
+	for _, preset := range h.Presets {
+		names = append(names, preset.Name)
+	}
+
+	return names
+}
+
+func (h *Heater) state(device *pentaircloud.Device, setPointField string) (HeaterState, error) {
+	setPoint, err := strconv.ParseFloat(device.Fields[setPointField].Value, 64)
+
+	if err != nil {
+		return HeaterState{}, fmt.Errorf("failed to parse heater set point (%s): %s", setPointField, err)
+	}
+
+	state := HeaterState{SetPoint: setPoint, Mode: "off", Preset: "None"}
+
+	if field, ok := device.Fields[h.CurrentField]; ok {
+		current, err := strconv.ParseFloat(field.Value, 64)
+
+		if err != nil {
+			return HeaterState{}, fmt.Errorf("failed to parse temperature (%s): %s", h.CurrentField, err)
+		}
+
+		state.Current = &current
+	}
+
+	mode := device.Fields[h.ModeField].Value
+
+	for _, preset := range h.Presets {
+		if preset.Value == mode {
+			state.Mode = "heat"
+			state.Preset = preset.Name
+		}
+	}
+
+	return state, nil
+}
+
+// parseCommand handles the "mode" and "preset" commands of a Climate entity.
+func (h *Heater) parseCommand(command, payload string) (map[string]string, error) {
+	switch command {
+	case "mode":
+		switch payload {
+		case "off":
+			return map[string]string{h.ModeField: h.Off}, nil
+		case "heat":
+			return map[string]string{h.ModeField: h.Presets[0].Value}, nil
+		}
+
+		return nil, fmt.Errorf("unsupported heater mode %q", payload)
+	case "preset":
+		for _, preset := range h.Presets {
+			if preset.Name == payload {
+				return map[string]string{h.ModeField: preset.Value}, nil
+			}
+		}
+
+		return nil, fmt.Errorf("unknown heat source %q", payload)
+	}
+
+	return nil, fmt.Errorf("heater does not accept %s commands", command)
+}
@@ -0,0 +1,18 @@
+package devicetypes
+
+// IntelliChlor salt chlorine generators. Entities whose field a cell does not
+// report are skipped, so older cells without a temperature probe still work.
+func init() {
+	Register(Family{
+		Name:         "IntelliChlor",
+		Experimental: true,
+		Models:       []string{"IntelliChlor", "IntelliChlor IC40", "IntelliChlor IC60"},
+		Entities: []Entity{
+			{Key: "salt", Name: "Salt Level", Field: "ics1", Component: Sensor, Unit: "ppm"},
+			{Key: "output", Name: "Chlorine Output", Field: "ics2", Component: Sensor, Unit: "%"},
+			{Key: "celltemp", Name: "Cell Temperature", Field: "t0", Component: Sensor, DeviceClass: "temperature", Unit: "°F"},
+			{Key: "lowsalt", Name: "Low Salt", Field: "ics5", Component: BinarySensor, DeviceClass: "problem"},
+			{Key: "noflow", Name: "No Flow", Field: "ics6", Component: BinarySensor, DeviceClass: "problem"},
+		},
+	})
+}
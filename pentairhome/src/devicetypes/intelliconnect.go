@@ -0,0 +1,36 @@
+package devicetypes
+
+import "regexp"
+
+// IntelliConnect controllers with a variable speed pump and optional heater.
+// The target speed and heater fields accept writes; IntelliFlo pumps run
+// between 450 and 3450 rpm and heaters between 40 and 104 °F when the
+// controller does not report tighter bounds. Relays drive lights and
+// auxiliary equipment. None of the writes have been checked against a real
+// controller, and the heater and relay fields have not been seen on one.
+func init() {
+	Register(Family{
+		Name:     "IntelliConnect",
+		Models:   []string{"IntelliConnect"},
+		Relays:   regexp.MustCompile(`^r[0-9]+$`),
+		Schedule: &PumpSchedule{SpeedField: "ifs5", MinSpeed: 450, MaxSpeed: 3450},
+		Entities: []Entity{
+			{Key: "power", Name: "Pump Power", Field: "ifs3", Component: Sensor, DeviceClass: "power", Unit: "W"},
+			{Key: "actualspeed", Name: "Pump Speed", Field: "ifs4", Component: Sensor, DeviceClass: "speed", Unit: "rpm"},
+			{Key: "targetspeed", Name: "Pump Target Speed", Field: "ifs5", Component: Number, Unit: "rpm", Min: 450, Max: 3450, Step: 10},
+			{Key: "actualflow", Name: "Pump Flow", Field: "ifs6", Component: Sensor, DeviceClass: "volume_flow_rate", Unit: "gal/min"},
+			{Key: "actualtemp", Name: "Water Temperature", Field: "t0", Component: Sensor, DeviceClass: "temperature", Unit: "°F"},
+			{Key: "outsidetemp", Name: "Outside Temperature", Field: "t1", Component: Sensor, DeviceClass: "temperature", Unit: "°F"},
+			{Key: "heater", Name: "Heater", Field: "s13", Component: Climate, Unit: "°F", Min: 40, Max: 104, Step: 1, Heater: &Heater{
+				CurrentField: "t0",
+				ModeField:    "s12",
+				Off:          "0",
+				Presets: []HeatSource{
+					{Name: "Heater", Value: "1"},
+					{Name: "Solar Preferred", Value: "2"},
+					{Name: "Solar Only", Value: "3"},
+				},
+			}},
+		},
+	})
+}
@@ -0,0 +1,19 @@
+package devicetypes
+
+// IntelliFlo VSF pumps report the same pump fields as the pump side of an
+// IntelliConnect, without the temperature probes.
+func init() {
+	Register(Family{
+		Name:         "IntelliFlo VSF",
+		Experimental: true,
+		Models:       []string{"IntelliFlo VSF", "IntelliFlo3 VSF"},
+		Schedule:     &PumpSchedule{SpeedField: "ifs5", MinSpeed: 450, MaxSpeed: 3450},
+		Entities: []Entity{
+			{Key: "power", Name: "Pump Power", Field: "ifs3", Component: Sensor, DeviceClass: "power", Unit: "W"},
+			{Key: "actualspeed", Name: "Pump Speed", Field: "ifs4", Component: Sensor, DeviceClass: "speed", Unit: "rpm"},
+			{Key: "targetspeed", Name: "Pump Target Speed", Field: "ifs5", Component: Number, Unit: "rpm", Min: 450, Max: 3450, Step: 10},
+			{Key: "actualflow", Name: "Pump Flow", Field: "ifs6", Component: Sensor, DeviceClass: "volume_flow_rate", Unit: "gal/min"},
+			{Key: "running", Name: "Pump Running", Field: "ifs1", Component: BinarySensor, DeviceClass: "running"},
+		},
+	})
+}
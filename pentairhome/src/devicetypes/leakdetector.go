@@ -0,0 +1,16 @@
+package devicetypes
+
+// Leak detectors are battery powered sensors with a probe and an optional
+// temperature reading for freeze alerts.
+func init() {
+	Register(Family{
+		Name:         "Leak Detector",
+		Experimental: true,
+		Models:       []string{"Leak Detector"},
+		Entities: []Entity{
+			{Key: "leak", Name: "Leak", Field: "ld1", Component: BinarySensor, DeviceClass: "moisture"},
+			{Key: "battery", Name: "Battery", Field: "ld2", Component: Sensor, DeviceClass: "battery", Unit: "%"},
+			{Key: "temperature", Name: "Temperature", Field: "t0", Component: Sensor, DeviceClass: "temperature", Unit: "°F"},
+		},
+	})
+}
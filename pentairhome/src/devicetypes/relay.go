@@ -0,0 +1,35 @@
+package devicetypes
+
+import (
+	"fmt"
+	"pentairhome/pentaircloud"
+	"strings"
+)
+
+// relayEntity builds the entity for a relay field. The field's name is the
+// circuit name set in the Pentair Home app, so circuits named after a light
+// become lights and everything else a switch.
+func relayEntity(code string, field pentaircloud.DeviceField) Entity {
+	entity := Entity{Key: code, Field: code, Name: field.Name, Component: Switch}
+
+	if entity.Name == "" || entity.Name == code {
+		entity.Name = "Relay " + strings.TrimLeft(code, "abcdefghijklmnopqrstuvwxyz")
+	}
+
+	if strings.Contains(strings.ToLower(entity.Name), "light") {
+		entity.Component = Light
+	}
+
+	return entity
+}
+
+func (e Entity) parseRelayCommand(payload string) (map[string]string, error) {
+	switch strings.ToUpper(strings.TrimSpace(payload)) {
+	case "ON":
+		return map[string]string{e.Field: "1"}, nil
+	case "OFF":
+		return map[string]string{e.Field: "0"}, nil
+	}
+
+	return nil, fmt.Errorf("invalid payload %q for %s, expected ON or OFF", payload, e.Key)
+}
@@ -0,0 +1,18 @@
+package devicetypes
+
+// Sump pump monitors report the water level in the pit, the backup battery and
+// whether the primary pump is running.
+func init() {
+	Register(Family{
+		Name:         "Sump Pump Monitor",
+		Experimental: true,
+		Models:       []string{"Sump Pump Monitor", "SSPM"},
+		Entities: []Entity{
+			{Key: "waterlevel", Name: "Water Level", Field: "s1", Component: Sensor, DeviceClass: "distance", Unit: "in"},
+			{Key: "battery", Name: "Backup Battery", Field: "s2", Component: Sensor, DeviceClass: "battery", Unit: "%"},
+			{Key: "pumprunning", Name: "Pump Running", Field: "s3", Component: BinarySensor, DeviceClass: "running"},
+			{Key: "highwater", Name: "High Water", Field: "s4", Component: BinarySensor, DeviceClass: "moisture"},
+			{Key: "poweroutage", Name: "Power Outage", Field: "s5", Component: BinarySensor, DeviceClass: "problem"},
+		},
+	})
+}
@@ -0,0 +1,121 @@
+package devicetypes
+
+import (
+	"math"
+	"pentairhome/pentaircloud"
+	"strconv"
+	"strings"
+)
+
+// UnitSystem is a set of units Home Assistant is sent readings in.
+type UnitSystem string
+
+const (
+	Imperial UnitSystem = "imperial"
+	Metric   UnitSystem = "metric"
+)
+
+// ParseUnitSystem reads a unit preference, accepting either a system name or
+// the temperature unit it implies. The second result is false for anything
+// else.
+func ParseUnitSystem(value string) (UnitSystem, bool) {
+	switch strings.ToLower(strings.TrimPrefix(strings.TrimSpace(value), "°")) {
+	case "imperial", "us", "f", "fahrenheit":
+		return Imperial, true
+	case "metric", "si", "c", "celsius":
+		return Metric, true
+	}
+
+	return "", false
+}
+
+// conversion is how a unit entities declare converts to its metric
+// counterpart.
+type conversion struct {
+	unit  string
+	scale float64
+	// offset is subtracted before scaling, for temperatures
+	offset float64
+}
+
+// Entities declare the imperial unit of their readings; these are the ones
+// with a metric counterpart.
+var conversions = map[string]conversion{
+	"°F":      {unit: "°C", scale: 5.0 / 9, offset: 32},
+	"gal/min": {unit: "L/min", scale: 3.785411784},
+	"gal":     {unit: "L", scale: 3.785411784},
+	"in":      {unit: "cm", scale: 2.54},
+}
+
+// Units converts readings, which Pentair reports in imperial units, to the
+// unit system Home Assistant is sent them in, and commands back.
+type Units struct {
+	Published UnitSystem
+}
+
+// Unit is the unit the entity's values are published in.
+func (u Units) Unit(e Entity) string {
+	if conversion, ok := conversions[e.Unit]; ok && u.Published == Metric {
+		return conversion.unit
+	}
+
+	return e.Unit
+}
+
+func (u Units) convert(e Entity, value float64) float64 {
+	conversion, ok := conversions[e.Unit]
+
+	if !ok || u.Published != Metric {
+		return value
+	}
+
+	return round((value-conversion.offset)*conversion.scale, 2)
+}
+
+// Value converts a value returned by the entity's Value method.
+func (u Units) Value(e Entity, value any) any {
+	switch value := value.(type) {
+	case float64:
+		return u.convert(e, value)
+	case HeaterState:
+		if value.Current != nil {
+			current := u.convert(e, *value.Current)
+			value.Current = &current
+		}
+
+		value.SetPoint = u.convert(e, value.SetPoint)
+		return value
+	}
+
+	return value
+}
+
+// Bounds returns the entity's Bounds in the published units.
+func (u Units) Bounds(e Entity, device *pentaircloud.Device) (float64, float64) {
+	minimum, maximum := e.Bounds(device)
+	return u.convert(e, minimum), u.convert(e, maximum)
+}
+
+// Command converts a numeric command payload back to imperial units, rounded
+// to the entity's Step. Other payloads are returned as they are.
+func (u Units) Command(e Entity, payload string) string {
+	value, err := strconv.ParseFloat(strings.TrimSpace(payload), 64)
+	conversion, ok := conversions[e.Unit]
+
+	if err != nil || !ok || u.Published != Metric {
+		return payload
+	}
+
+	value = round(value/conversion.scale+conversion.offset, 2)
+
+	if e.Step > 0 {
+		value = math.Round(value/e.Step) * e.Step
+	}
+
+	return formatNumber(value)
+}
+
+func round(value float64, places int) float64 {
+	scale := math.Pow(10, float64(places))
+	return math.Round(value*scale) / scale
+}
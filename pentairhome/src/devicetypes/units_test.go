@@ -0,0 +1,60 @@
+package devicetypes
+
+import (
+	"pentairhome/pentaircloud"
+	"testing"
+)
+
+func TestUnits(t *testing.T) {
+	temperature := Entity{Key: "actualtemp", Component: Sensor, Unit: "°F"}
+	flow := Entity{Key: "actualflow", Component: Sensor, Unit: "gal/min"}
+	speed := Entity{Key: "actualspeed", Component: Sensor, Unit: "rpm"}
+	toMetric := Units{Published: Metric}
+
+	if unit := toMetric.Unit(temperature); unit != "°C" {
+		t.Errorf("expected °C, got %s", unit)
+	}
+
+	if value := toMetric.Value(temperature, 77.0); value != 25.0 {
+		t.Errorf("expected 25 °C, got %v", value)
+	}
+
+	if value := toMetric.Value(flow, 10.0); value != 37.85 {
+		t.Errorf("expected 37.85 L/min, got %v", value)
+	}
+
+	if value := toMetric.Value(speed, 2000.0); value != 2000.0 {
+		t.Errorf("expected speed to be left alone, got %v", value)
+	}
+
+	var heater Entity
+	family, _ := Lookup("IntelliConnect")
+
+	for _, entity := range family.Entities {
+		if entity.Key == "heater" {
+			heater = entity
+		}
+	}
+
+	if payload := toMetric.Command(heater, "28"); payload != "82" {
+		t.Errorf("expected 28 °C to be sent as 82 °F, got %s", payload)
+	}
+
+	if minimum, maximum := toMetric.Bounds(heater, &pentaircloud.Device{}); minimum != 4.44 || maximum != 40 {
+		t.Errorf("expected bounds 4.44 to 40 °C, got %v to %v", minimum, maximum)
+	}
+
+	value, _, _ := heater.Value(&pentaircloud.Device{Fields: map[string]pentaircloud.DeviceField{"s13": {Value: "77"}, "s12": {Value: "1"}}})
+
+	if state, ok := toMetric.Value(heater, value).(HeaterState); !ok || state.SetPoint != 25 {
+		t.Errorf("expected a 25 °C set point, got %+v", state)
+	}
+
+	if system, ok := ParseUnitSystem("°C"); !ok || system != Metric {
+		t.Errorf("expected °C to mean metric, got %q", system)
+	}
+
+	if _, ok := ParseUnitSystem(""); ok {
+		t.Error("expected no unit system from an empty preference")
+	}
+}
@@ -0,0 +1,18 @@
+package devicetypes
+
+// Water softeners report salt and capacity remaining along with the water used
+// today.
+func init() {
+	Register(Family{
+		Name:         "Water Softener",
+		Experimental: true,
+		Models:       []string{"Water Softener"},
+		Entities: []Entity{
+			{Key: "saltlevel", Name: "Salt Level", Field: "ws1", Component: Sensor, Unit: "%"},
+			{Key: "capacity", Name: "Capacity Remaining", Field: "ws2", Component: Sensor, Unit: "%"},
+			// Resets to zero each day, which total_increasing treats as a new cycle
+			{Key: "waterused", Name: "Water Used Today", Field: "ws3", Component: Sensor, DeviceClass: "water", StateClass: "total_increasing", Unit: "gal"},
+			{Key: "regenerating", Name: "Regenerating", Field: "ws4", Component: BinarySensor, DeviceClass: "running"},
+		},
+	})
+}
@@ -2,23 +2,19 @@ package main
 
 import (
 	"context"
-	"encoding/json"
-	"errors"
+	"crypto/tls"
 	"flag"
-	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"pentairhome/bridge"
 	"pentairhome/cognito"
 	"pentairhome/config"
+	"pentairhome/devicetypes"
 	"pentairhome/mqtt"
 	"pentairhome/pentaircloud"
-	"pentairhome/sensor"
-	"sort"
+	"pentairhome/supervisor"
 	"syscall"
-	"time"
-
-	"github.com/eclipse/paho.golang/paho"
 )
 
 func main() {
@@ -36,195 +32,120 @@ func main() {
 		os.Exit(1)
 	}
 
-	apiClient := makeApiClient(ctx, runtimeConfiguration)
-
-	devices, err := apiClient.ListDevices()
-
-	if err != nil {
-		panic(err)
-	}
-
-	intelliConnectIdx := sort.Search(len(devices), func(i int) bool {
-		return devices[i].IsIntelliConnect()
-	})
-
-	if intelliConnectIdx < 0 {
-		panic(errors.New("no IntelliConnect devices found"))
-	}
-
-	device, deviceErr := apiClient.GetDevice(devices[intelliConnectIdx].DeviceID)
-
-	if deviceErr != nil {
-		log.Panicf("failed to get IntelliConnect device: %s", deviceErr)
-	}
+	tokens := cognito.NewTokenManager(runtimeConfiguration.Endpoints, cognito.Login{
+		Username:    runtimeConfiguration.PentairHomeUsername,
+		Password:    runtimeConfiguration.PentairHomePassword,
+		TOTPSecret:  runtimeConfiguration.PentairHomeTOTPSecret,
+		SMSCode:     runtimeConfiguration.PentairHomeMFACode,
+		NewPassword: runtimeConfiguration.PentairHomeNewPassword,
+	}, cognito.LoadSessionStore(runtimeConfiguration.SessionFile(), runtimeConfiguration.PentairHomeUsername))
+	apiClient := makeApiClient(ctx, runtimeConfiguration, tokens)
 
 	mqttClient, mqttErr := mqtt.MakeClient(mqtt.MQTTConfig{
-		Context:  ctx,
-		Host:     runtimeConfiguration.MQTTHost,
-		Port:     runtimeConfiguration.MQTTPort,
-		Username: runtimeConfiguration.MQTTUsername,
-		Password: runtimeConfiguration.MQTTPassword,
+		Context:         ctx,
+		Scheme:          runtimeConfiguration.MQTTScheme,
+		Host:            runtimeConfiguration.MQTTHost,
+		Port:            runtimeConfiguration.MQTTPort,
+		Path:            runtimeConfiguration.MQTTPath,
+		TLS:             makeMQTTTLSConfig(runtimeConfiguration),
+		Username:        runtimeConfiguration.MQTTUsername,
+		Password:        runtimeConfiguration.MQTTPassword,
+		ClientID:        runtimeConfiguration.MQTTClientID,
+		QoS:             byte(runtimeConfiguration.MQTTQoS),
+		RetainDiscovery: runtimeConfiguration.MQTTRetainDiscovery,
+		RetainState:     runtimeConfiguration.MQTTRetainState,
+		Topics: mqtt.Topics{
+			Base:            runtimeConfiguration.MQTTBaseTopic,
+			DiscoveryPrefix: runtimeConfiguration.MQTTDiscoveryPrefix,
+		},
 	})
 
 	if mqttErr != nil {
-		log.Panicf("failed to create MQTT client: %s", mqttErr)
+		log.Fatalf("failed to create MQTT client: %s", mqttErr)
 	}
 
-	sendSensorConfig(mqttClient, device)
-	sendSensorData(mqttClient, device)
-
-	pollSensorData(ctx, mqttClient, apiClient, device, runtimeConfiguration)
-	listenForStatusMessages(ctx, mqttClient, apiClient, device, runtimeConfiguration)
-
-	<-mqttClient.Client.Done()
-}
+	pentairBridge := bridge.New(mqttClient, apiClient)
+	pentairBridge.ExposeUnknownFields = runtimeConfiguration.ExposeUnknownFields
+	pentairBridge.ExperimentalDevices = runtimeConfiguration.ExperimentalDevices
+	pentairBridge.ExperimentalControls = runtimeConfiguration.ExperimentalControls
+	pentairBridge.IoTEndpoint = runtimeConfiguration.Endpoints.IoTEndpoint
+	pentairBridge.Energy = bridge.LoadEnergyStore(runtimeConfiguration.EnergyFile())
+	pentairBridge.Heartbeat = runtimeConfiguration.HeartbeatInterval
+	// Already checked by ValidateRuntimeConfiguration
+	pentairBridge.Deadbands, _ = runtimeConfiguration.DeadbandsByClass()
 
-func makeApiClient(ctx context.Context, runtimeConfiguration config.RuntimeConfiguration) *pentaircloud.APIClient {
-	identity, err := cognito.AuthenticateWithUsernameAndPassword(ctx, runtimeConfiguration.PentairHomeUsername, runtimeConfiguration.PentairHomePassword)
+	// Left empty for auto, which RunDiscovery reads from the account
+	pentairBridge.UnitSystem = ""
 
-	if err != nil {
-		panic(err)
+	if runtimeConfiguration.UnitSystem != "auto" {
+		pentairBridge.UnitSystem = devicetypes.UnitSystem(runtimeConfiguration.UnitSystem)
 	}
 
-	credentials, err := cognito.GetCredentialsFromAuthentication(ctx, identity)
-
-	if err != nil {
-		panic(err)
+	pentairBridge.Polling = bridge.Polling{
+		Interval:   runtimeConfiguration.PollInterval,
+		Fast:       runtimeConfiguration.PollIntervalFast,
+		Slow:       runtimeConfiguration.PollIntervalSlow,
+		Adaptive:   runtimeConfiguration.AdaptivePolling,
+		QuietStart: runtimeConfiguration.QuietHoursStart,
+		QuietEnd:   runtimeConfiguration.QuietHoursEnd,
 	}
 
-	return pentaircloud.NewAPIClient(ctx, *identity.IdToken, *credentials.AccessKeyId, *credentials.SecretKey, *credentials.SessionToken)
-}
-
-func listenForStatusMessages(ctx context.Context, mqttClient *mqtt.MQTTWrapper, apiClient *pentaircloud.APIClient, device *pentaircloud.Device, runtimeConfiguration config.RuntimeConfiguration) {
-	go func() {
-		for {
-			select {
-			case statusMessage := <-mqttClient.StatusMessages:
-				log.Printf("Received status message: %s", statusMessage)
-				if statusMessage == "online" {
-					log.Println("Home Assistant is online")
-
-					defer func() {
-						if r := recover(); r != nil {
-							log.Println("Recovered from panic in listening for status messages. Making new API client and listening again.")
-							apiClient = makeApiClient(ctx, runtimeConfiguration)
-							listenForStatusMessages(ctx, mqttClient, apiClient, device, runtimeConfiguration)
-							mqttClient.StatusMessages <- statusMessage
-						}
-					}()
-
-					log.Printf("Sending sensor config for device: %s", device.DeviceID)
-					sendSensorConfig(mqttClient, device)
-				}
-			case <-ctx.Done():
-				log.Println("Shutting down status message listener")
-				return
-			}
-		}
-	}()
-}
+	workers := supervisor.New(ctx)
+	workers.Fatal = cognito.IsFatal
 
-func pollSensorData(ctx context.Context, mqttClient *mqtt.MQTTWrapper, apiClient *pentaircloud.APIClient, device *pentaircloud.Device, runtimeConfiguration config.RuntimeConfiguration) {
-	ticker := time.NewTicker(60 * time.Second)
-
-	go func() {
-		for {
-			select {
-			case <-ticker.C:
-				defer func() {
-					if r := recover(); r != nil {
-						log.Println("Recovered from panic in sensor data polling. Making new API client and restarting polling.")
-						apiClient = makeApiClient(ctx, runtimeConfiguration)
-						pollSensorData(ctx, mqttClient, apiClient, device, runtimeConfiguration)
-					}
-				}()
-
-				device, err := apiClient.GetDevice(device.DeviceID)
-
-				if err != nil {
-					panic(err)
-				}
-
-				sendSensorData(mqttClient, device)
-			case <-ctx.Done():
-				log.Println("Shutting down sensor data polling")
-				ticker.Stop()
-				return
-			}
-		}
-	}()
-}
+	workers.Go("device discovery", pentairBridge.RunDiscovery)
+	workers.Go("sensor data poller", pentairBridge.RunPoller)
+	workers.Go("status message listener", pentairBridge.RunStatusListener)
+	workers.Go("command listener", pentairBridge.RunCommandListener)
 
-func sendSensorConfig(mqttClient *mqtt.MQTTWrapper, device *pentaircloud.Device) {
-	sensorConfigs := []sensor.SensorConfig{
-		sensor.GenerateSensorConfig(device, "Pump Power", "power", "power", "W"),
-		sensor.GenerateSensorConfig(device, "Pump Speed", "actualspeed", "speed", "rpm"),
-		sensor.GenerateSensorConfig(device, "Pump Flow", "actualflow", "volume_flow_rate", "gal/min"),
-		sensor.GenerateSensorConfig(device, "Water Temperature", "actualtemp", "temperature", "°F"),
-		sensor.GenerateSensorConfig(device, "Outside Temperature", "outsidetemp", "temperature", "°F"),
+	if pentairBridge.IoTEndpoint != "" {
+		workers.Go("shadow push", pentairBridge.RunPush)
 	}
 
-	for _, config := range sensorConfigs {
-		message, err := json.Marshal(config)
-		if err != nil {
-			panic(err)
-		}
+	<-workers.Done()
+	stop()
+	<-mqttClient.Client.Done()
+	workers.Wait()
 
-		topic := fmt.Sprintf("homeassistant/sensor/%s/config", config.UniqueID)
-		if _, err = mqttClient.Publish(topic, message); err != nil {
-			panic(err)
-		} else {
-			log.Printf("Published sensor config to %s", topic)
-		}
+	if err := workers.Err(); err != nil {
+		log.Printf("Stopping, fix the add-on configuration and restart it: %s", err)
+		os.Exit(1)
 	}
 }
 
-func sendSensorData(mqttClient *mqtt.MQTTWrapper, device *pentaircloud.Device) (pubResp *paho.PublishResponse) {
-	power, err := device.GetActualPower()
-	if err != nil {
-		panic(err)
-	}
-
-	actualSpeed, err := device.GetActualSpeed()
-	if err != nil {
-		panic(err)
-	}
-
-	actualFlow, err := device.GetActualFlow()
-	if err != nil {
-		panic(err)
+// makeMQTTTLSConfig loads the certificates for the MQTT connection, or returns
+// nil when it does not use TLS.
+func makeMQTTTLSConfig(runtimeConfiguration config.RuntimeConfiguration) *tls.Config {
+	if !runtimeConfiguration.MQTTTLS() {
+		return nil
 	}
 
-	actualTemp, err := device.GetActualTemp()
-	if err != nil {
-		panic(err)
-	}
+	tlsConfig, err := mqtt.TLSFiles{
+		CAFile:             runtimeConfiguration.ConfigFile(runtimeConfiguration.MQTTCAFile),
+		CertFile:           runtimeConfiguration.ConfigFile(runtimeConfiguration.MQTTCertFile),
+		KeyFile:            runtimeConfiguration.ConfigFile(runtimeConfiguration.MQTTKeyFile),
+		InsecureSkipVerify: runtimeConfiguration.MQTTInsecureSkipVerify,
+	}.Config()
 
-	outsideTemp, err := device.GetOutsideTemp()
 	if err != nil {
-		panic(err)
+		log.Fatalf("failed to set up MQTT TLS: %s", err)
 	}
 
-	sensorData := sensor.SensorData{
-		Power:       power,
-		ActualSpeed: actualSpeed,
-		ActualFlow:  actualFlow,
-		ActualTemp:  actualTemp,
-		OutsideTemp: outsideTemp,
+	if runtimeConfiguration.MQTTInsecureSkipVerify {
+		log.Println("MQTT broker certificate is not being verified")
 	}
 
-	sensorDataJSON, err := json.Marshal(sensorData)
-
-	if err != nil {
-		panic(err)
-	}
-
-	topic := fmt.Sprintf("pentairhome/%s", device.DeviceID)
-	pubResp, err = mqttClient.Publish(topic, sensorDataJSON)
+	return tlsConfig
+}
 
-	if err != nil {
-		panic(err)
+func makeApiClient(ctx context.Context, runtimeConfiguration config.RuntimeConfiguration, tokens *cognito.TokenManager) *pentaircloud.APIClient {
+	apiClient := pentaircloud.NewAPIClient(ctx, runtimeConfiguration.Endpoints, tokens)
+	apiClient.RetryPolicy = pentaircloud.RetryPolicy{
+		MaxAttempts: runtimeConfiguration.RetryMaxAttempts,
+		BaseDelay:   runtimeConfiguration.RetryBaseDelay,
+		MaxDelay:    runtimeConfiguration.RetryMaxDelay,
+		Jitter:      runtimeConfiguration.RetryJitter,
 	}
 
-	return pubResp
+	return apiClient
 }
@@ -2,34 +2,75 @@ package mqtt
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"log"
 	"net/url"
+	"strings"
 
 	"github.com/eclipse/paho.golang/autopaho"
 	"github.com/eclipse/paho.golang/paho"
 )
 
+// MQTTConfig describes the broker to connect to and how to publish.
 type MQTTConfig struct {
-	Context  context.Context
-	Host     string
-	Port     string
+	Context context.Context
+	// Scheme is mqtt, mqtts, ws or wss
+	Scheme string
+	Host   string
+	Port   string
+	// Path is the endpoint of a websocket broker
+	Path string
+	// TLS is used by mqtts and wss
+	TLS      *tls.Config
 	Username string
 	Password string
+	ClientID string
+	// QoS applies to discovery, state and subscriptions
+	QoS byte
+	// RetainDiscovery and RetainState make the broker keep the last discovery
+	// configs and states for Home Assistant to read when it starts
+	RetainDiscovery bool
+	RetainState     bool
+	Topics          Topics
 }
 
 type MQTTWrapper struct {
 	Client         *autopaho.ConnectionManager
 	Context        context.Context
 	StatusMessages chan string
+	Commands       chan Command
+	Topics         Topics
+
+	qos             byte
+	retainDiscovery bool
+	retainState     bool
+}
+
+// Command is a message received on one of the add-on's command topics.
+type Command struct {
+	Topic   string
+	Payload string
 }
 
-func (mqttWrapper *MQTTWrapper) Publish(topic string, payload []byte) (*paho.PublishResponse, error) {
+// PublishDiscovery publishes an entity's discovery config, or an empty
+// payload to remove the entity.
+func (mqttWrapper *MQTTWrapper) PublishDiscovery(topic string, payload []byte) (*paho.PublishResponse, error) {
+	return mqttWrapper.publish(topic, payload, mqttWrapper.retainDiscovery)
+}
+
+// PublishState publishes a device's state or schedule.
+func (mqttWrapper *MQTTWrapper) PublishState(topic string, payload []byte) (*paho.PublishResponse, error) {
+	return mqttWrapper.publish(topic, payload, mqttWrapper.retainState)
+}
+
+func (mqttWrapper *MQTTWrapper) publish(topic string, payload []byte, retain bool) (*paho.PublishResponse, error) {
 	log.Printf("publishing data to topic: %s", topic)
 
 	resp, err := mqttWrapper.Client.Publish(mqttWrapper.Context, &paho.Publish{
 		Topic:   topic,
-		QoS:     byte(0),
+		QoS:     mqttWrapper.qos,
+		Retain:  retain,
 		Payload: payload,
 	})
 
@@ -40,48 +81,116 @@ func (mqttWrapper *MQTTWrapper) Publish(topic string, payload []byte) (*paho.Pub
 	return resp, nil
 }
 
+// PublishRetained publishes a message the broker keeps for clients that
+// subscribe later, such as availability.
+func (mqttWrapper *MQTTWrapper) PublishRetained(topic string, payload []byte) (*paho.PublishResponse, error) {
+	resp, err := mqttWrapper.Client.Publish(mqttWrapper.Context, &paho.Publish{
+		Topic:   topic,
+		QoS:     byte(1),
+		Retain:  true,
+		Payload: payload,
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to publish message: %s", err)
+	}
+
+	return resp, nil
+}
+
+func brokerURL(config MQTTConfig) (*url.URL, error) {
+	scheme := config.Scheme
+
+	if scheme == "" {
+		scheme = "mqtt"
+	}
+
+	u, err := url.Parse(fmt.Sprintf("%s://%s:%s", scheme, config.Host, config.Port))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if scheme == "ws" || scheme == "wss" {
+		u.Path = config.Path
+	}
+
+	return u, nil
+}
+
+// willMessage is left with the broker to mark the add-on offline if its
+// connection drops, which makes every entity unavailable.
+func willMessage(topics Topics) *paho.WillMessage {
+	return &paho.WillMessage{
+		Topic:   topics.Availability(),
+		QoS:     byte(1),
+		Retain:  true,
+		Payload: []byte("offline"),
+	}
+}
+
 func MakeClient(config MQTTConfig) (*MQTTWrapper, error) {
 	log.Printf("MQTT Host: %s; Port: %s; Username: %s", config.Host, config.Port, config.Username)
 
-	u, err := url.Parse(fmt.Sprintf("mqtt://%s:%s", config.Host, config.Port))
+	u, err := brokerURL(config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse URL: %s", err)
 	}
 
 	statusMessages := make(chan string, 1)
+	commands := make(chan Command, 16)
 
 	cliCfg := autopaho.ClientConfig{
 		ServerUrls:                    []*url.URL{u},
+		TlsCfg:                        config.TLS,
 		KeepAlive:                     20,
 		CleanStartOnInitialConnection: true,
 		SessionExpiryInterval:         60,
 		OnConnectionUp: func(cm *autopaho.ConnectionManager, connAck *paho.Connack) {
 			fmt.Println("mqtt connection up")
 
-			if _, err := cm.Subscribe(config.Context, &paho.Subscribe{
+			// Replaces the Last Will left by a previous connection
+			if _, err := cm.Publish(config.Context, &paho.Publish{
+				Topic:   config.Topics.Availability(),
+				QoS:     byte(1),
+				Retain:  true,
+				Payload: []byte("online"),
+			}); err != nil {
+				log.Printf("failed to publish availability: %s", err)
+			}
+
+			subscription := &paho.Subscribe{
 				Subscriptions: []paho.SubscribeOptions{
 					{
-						Topic: "homeassistant/status",
-						QoS:   byte(0),
+						Topic: config.Topics.HomeAssistantStatus(),
+						QoS:   config.QoS,
 					},
 				},
-			}); err != nil {
-				log.Printf("failed to subscribe (%s). This is likely to mean homeassistant restarts will require manual addon restart.", err)
+			}
+
+			for _, topic := range config.Topics.Commands() {
+				subscription.Subscriptions = append(subscription.Subscriptions, paho.SubscribeOptions{Topic: topic, QoS: config.QoS})
+			}
+
+			if _, err := cm.Subscribe(config.Context, subscription); err != nil {
+				log.Printf("failed to subscribe (%s). This is likely to mean homeassistant restarts will require manual addon restart and commands will be ignored.", err)
 				return
 			}
 
-			log.Println("subscribed to homeassistant/status")
+			log.Printf("subscribed to %s and %s", config.Topics.HomeAssistantStatus(), strings.Join(config.Topics.Commands(), ", "))
 		},
 		OnConnectError: func(err error) { log.Printf("error whilst attempting connection: %s\n", err) },
 		ClientConfig: paho.ClientConfig{
-			ClientID:      "pentairhome",
+			ClientID:      config.ClientID,
 			OnClientError: func(err error) { fmt.Printf("client error: %s\n", err) },
 			OnPublishReceived: []func(paho.PublishReceived) (bool, error){
 				func(pr paho.PublishReceived) (bool, error) {
 					msg := pr.Packet
 
-					if msg.Topic == "homeassistant/status" {
+					if msg.Topic == config.Topics.HomeAssistantStatus() {
 						statusMessages <- string(msg.Payload)
+					} else if _, _, _, ok := config.Topics.ParseCommand(msg.Topic); ok {
+						commands <- Command{Topic: msg.Topic, Payload: string(msg.Payload)}
 					}
 
 					return true, nil
@@ -90,6 +199,12 @@ func MakeClient(config MQTTConfig) (*MQTTWrapper, error) {
 		},
 		ConnectUsername: config.Username,
 		ConnectPassword: []byte(config.Password),
+		WillMessage:     willMessage(config.Topics),
+		// Disconnect with Will Message, so shutting down also marks the add-on
+		// offline
+		DisconnectPacketBuilder: func() *paho.Disconnect {
+			return &paho.Disconnect{ReasonCode: 0x04}
+		},
 	}
 
 	c, err := autopaho.NewConnection(config.Context, cliCfg)
@@ -105,8 +220,13 @@ func MakeClient(config MQTTConfig) (*MQTTWrapper, error) {
 	fmt.Printf("Connected to %s\n", u)
 
 	return &MQTTWrapper{
-		Client:         c,
-		Context:        config.Context,
-		StatusMessages: statusMessages,
+		Client:          c,
+		Context:         config.Context,
+		StatusMessages:  statusMessages,
+		Commands:        commands,
+		Topics:          config.Topics,
+		qos:             config.QoS,
+		retainDiscovery: config.RetainDiscovery,
+		retainState:     config.RetainState,
 	}, nil
 }
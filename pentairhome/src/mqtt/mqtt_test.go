@@ -0,0 +1,17 @@
+package mqtt
+
+import "testing"
+
+func TestWillMessage(t *testing.T) {
+	will := willMessage(Topics{Base: "pentairhome", DiscoveryPrefix: "homeassistant"})
+
+	if will.Topic != "pentairhome/status" || string(will.Payload) != "offline" {
+		t.Errorf("will = %s on %s, want offline on pentairhome/status", will.Payload, will.Topic)
+	}
+
+	// Home Assistant only sees the add-on went offline if the broker keeps
+	// the message for it
+	if !will.Retain || will.QoS != 1 {
+		t.Errorf("will retain = %t and QoS = %d, want retained at QoS 1", will.Retain, will.QoS)
+	}
+}
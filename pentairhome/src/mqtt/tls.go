@@ -0,0 +1,56 @@
+package mqtt
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// TLSFiles are the PEM files that secure the connection to the broker.
+// CAFile replaces the system roots, for brokers with a private CA, and
+// CertFile and KeyFile are the client certificate for brokers that require
+// one. InsecureSkipVerify accepts any server certificate and is only meant for
+// testing.
+type TLSFiles struct {
+	CAFile             string
+	CertFile           string
+	KeyFile            string
+	InsecureSkipVerify bool
+}
+
+// Config loads the files into a TLS configuration.
+func (f TLSFiles) Config() (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: f.InsecureSkipVerify,
+	}
+
+	if f.CAFile != "" {
+		bundle, err := os.ReadFile(f.CAFile)
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %s", err)
+		}
+
+		roots := x509.NewCertPool()
+
+		if !roots.AppendCertsFromPEM(bundle) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", f.CAFile)
+		}
+
+		config.RootCAs = roots
+	}
+
+	if f.CertFile != "" || f.KeyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(f.CertFile, f.KeyFile)
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %s", err)
+		}
+
+		config.Certificates = []tls.Certificate{certificate}
+	}
+
+	return config, nil
+}
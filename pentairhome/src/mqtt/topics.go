@@ -0,0 +1,63 @@
+package mqtt
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Topics names the topics the add-on uses. Base starts the add-on's own
+// topics and DiscoveryPrefix the ones Home Assistant discovers entities and
+// announces its status on.
+type Topics struct {
+	Base            string
+	DiscoveryPrefix string
+}
+
+// Availability says whether the add-on is connected. It is set to offline by
+// the broker through the Last Will when the connection drops.
+func (t Topics) Availability() string {
+	return t.Base + "/status"
+}
+
+// HomeAssistantStatus is where Home Assistant announces it is online.
+func (t Topics) HomeAssistantStatus() string {
+	return t.DiscoveryPrefix + "/status"
+}
+
+// Device returns the device's state topic, or with parts a topic below it.
+func (t Topics) Device(deviceID string, parts ...string) string {
+	return strings.Join(append([]string{t.Base, deviceID}, parts...), "/")
+}
+
+// Discovery is where the discovery config of an entity is published.
+func (t Topics) Discovery(component, uniqueID string) string {
+	return fmt.Sprintf("%s/%s/%s/config", t.DiscoveryPrefix, component, uniqueID)
+}
+
+// Commands match the command topics of every entity that accepts commands,
+// <base>/<device ID>/<entity key>/set and, for entities with several
+// settings, <base>/<device ID>/<entity key>/<setting>/set.
+func (t Topics) Commands() []string {
+	return []string{t.Base + "/+/+/set", t.Base + "/+/+/+/set"}
+}
+
+// ParseCommand splits a command topic into the device ID, the entity key and
+// the setting, which is empty for the entity's own value.
+func (t Topics) ParseCommand(topic string) (string, string, string, bool) {
+	rest, ok := strings.CutPrefix(topic, t.Base+"/")
+
+	if !ok {
+		return "", "", "", false
+	}
+
+	parts := strings.Split(rest, "/")
+
+	switch {
+	case len(parts) == 3 && parts[2] == "set":
+		return parts[0], parts[1], "", true
+	case len(parts) == 4 && parts[3] == "set":
+		return parts[0], parts[1], parts[2], true
+	}
+
+	return "", "", "", false
+}
@@ -0,0 +1,32 @@
+package mqtt
+
+import "testing"
+
+func TestParseCommand(t *testing.T) {
+	topics := Topics{Base: "pool/pentair", DiscoveryPrefix: "homeassistant"}
+
+	tests := []struct {
+		topic   string
+		key     string
+		setting string
+		ok      bool
+	}{
+		{"pool/pentair/abc123/targetspeed/set", "targetspeed", "", true},
+		{"pool/pentair/abc123/heater/mode/set", "heater", "mode", true},
+		{"pool/pentair/abc123", "", "", false},
+		{"pentairhome/abc123/targetspeed/set", "", "", false},
+		{"pool/pentair/abc123/targetspeed/get", "", "", false},
+	}
+
+	for _, test := range tests {
+		deviceID, key, setting, ok := topics.ParseCommand(test.topic)
+
+		if ok != test.ok || key != test.key || setting != test.setting || (ok && deviceID != "abc123") {
+			t.Errorf("ParseCommand(%q) = %q, %q, %q, %t", test.topic, deviceID, key, setting, ok)
+		}
+	}
+
+	if topic := topics.Device("abc123", "heater", "mode", "set"); topic != "pool/pentair/abc123/heater/mode/set" {
+		t.Errorf("unexpected command topic %s", topic)
+	}
+}
@@ -8,48 +8,63 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"pentairhome/cognito"
 	"pentairhome/config"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
-	credentials "github.com/aws/aws-sdk-go-v2/credentials"
 )
 
+// APIClient is safe to share between goroutines. Its fields are not changed
+// after construction and the login state it depends on is guarded by the
+// TokenManager and CredentialsCache.
 type APIClient struct {
-	HttpClient   *http.Client
-	Context      context.Context
-	IDToken      *string
-	AccessKeyId  *string
-	AWSRegion    *string
-	SecretKey    *string
-	SessionToken *string
-	CredsCache   *aws.CredentialsCache
+	BaseURL     string
+	HttpClient  *http.Client
+	Context     context.Context
+	Tokens      *cognito.TokenManager
+	AWSRegion   *string
+	CredsCache  *aws.CredentialsCache
+	RetryPolicy RetryPolicy
 }
 
-func NewAPIClient(ctx context.Context, idToken, accessKey, secretKey, sessionToken string) *APIClient {
-	config := config.FetchConfiguration()
+// Identity pool credentials are renewed this long before they expire.
+const credentialsExpiryWindow = 5 * time.Minute
 
-	credsCache := aws.NewCredentialsCache(credentials.NewStaticCredentialsProvider(
-		accessKey,
-		secretKey,
-		sessionToken,
-	))
+func NewAPIClient(ctx context.Context, endpoints config.Configuration, tokens *cognito.TokenManager) *APIClient {
+
+	credsCache := aws.NewCredentialsCache(cognito.NewIdentityCredentialsProvider(tokens), func(options *aws.CredentialsCacheOptions) {
+		options.ExpiryWindow = credentialsExpiryWindow
+	})
 
 	return &APIClient{
-		HttpClient:   new(http.Client),
-		Context:      ctx,
-		IDToken:      &idToken,
-		AccessKeyId:  &accessKey,
-		AWSRegion:    &config.AWSRegion,
-		SecretKey:    &secretKey,
-		SessionToken: &sessionToken,
-		CredsCache:   credsCache,
+		BaseURL:     strings.TrimSuffix(endpoints.APIBaseURL, "/") + "/",
+		HttpClient:  new(http.Client),
+		Context:     ctx,
+		Tokens:      tokens,
+		AWSRegion:   &endpoints.AWSRegion,
+		CredsCache:  credsCache,
+		RetryPolicy: DefaultRetryPolicy(),
 	}
 }
+
+// makeIdempotentRequest makes a request that is safe to repeat, retrying it
+// according to the client's retry policy.
+func (client APIClient) makeIdempotentRequest(endpoint, method string, body []byte) ([]byte, error) {
+	return client.RetryPolicy.Do(client.Context, endpoint, func() ([]byte, error) {
+		if body == nil {
+			return client.MakeRequest(endpoint, method, nil)
+		}
+
+		return client.MakeRequest(endpoint, method, bytes.NewReader(body))
+	})
+}
+
 func (client APIClient) MakeRequest(endpoint, method string, body io.Reader) ([]byte, error) {
-	url := fmt.Sprintf("%s%s", "https://api.pentair.cloud/", endpoint)
-	req, err := http.NewRequest(method, url, body)
+	url := fmt.Sprintf("%s%s", client.BaseURL, endpoint)
+	req, err := http.NewRequestWithContext(client.Context, method, url, body)
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %s", err)
@@ -58,10 +73,16 @@ func (client APIClient) MakeRequest(endpoint, method string, body io.Reader) ([]
 	awscred, err := client.CredsCache.Retrieve(client.Context)
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to retrieve credentials: %s", err)
+		return nil, fmt.Errorf("failed to retrieve credentials: %w", err)
 	}
 
-	req.Header.Set("x-amz-id-token", *client.IDToken)
+	idToken, err := client.Tokens.IdToken(client.Context)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve id token: %w", err)
+	}
+
+	req.Header.Set("x-amz-id-token", idToken)
 	req.Header.Set("user-agent", "aws-amplify/4.3.10 react-native")
 	req.Header.Set("content-type", "application/json; charset=UTF-8")
 
@@ -79,16 +100,26 @@ func (client APIClient) MakeRequest(endpoint, method string, body io.Reader) ([]
 	httpResp, httpErr := client.HttpClient.Do(req)
 
 	if httpErr != nil {
-		return nil, fmt.Errorf("failed to make request: %s", httpErr)
+		return nil, fmt.Errorf("failed to make request: %w", httpErr)
 	}
 
 	bodyBytes, readErr := io.ReadAll(httpResp.Body)
+	httpResp.Body.Close()
 
 	if readErr != nil {
 		return nil, fmt.Errorf("failed to read response body: %s", readErr)
 	}
 
-	httpResp.Body.Close()
+	if err := checkResponse(httpResp, bodyBytes, time.Now()); err != nil {
+		if IsAuthError(err) {
+			// Force new tokens and credentials on the next request rather
+			// than retrying with the ones that were just rejected.
+			client.Tokens.Invalidate()
+			client.CredsCache.Invalidate()
+		}
+
+		return nil, fmt.Errorf("%s %s: %w", method, endpoint, err)
+	}
 
 	return bodyBytes, nil
 }
@@ -1,10 +1,8 @@
 package pentaircloud
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
-	"strconv"
 )
 
 type DeviceRequest struct {
@@ -45,26 +43,6 @@ type Device struct {
 	ReportedDate int64                  `json:"reportedDate"`
 }
 
-func (d Device) GetActualPower() (float64, error) {
-	return strconv.ParseFloat(d.Fields["ifs3"].Value, 64)
-}
-
-func (d Device) GetActualSpeed() (float64, error) {
-	return strconv.ParseFloat(d.Fields["ifs4"].Value, 64)
-}
-
-func (d Device) GetActualFlow() (float64, error) {
-	return strconv.ParseFloat(d.Fields["ifs6"].Value, 64)
-}
-
-func (d Device) GetActualTemp() (float64, error) {
-	return strconv.ParseFloat(d.Fields["t0"].Value, 64)
-}
-
-func (d Device) GetOutsideTemp() (float64, error) {
-	return strconv.ParseFloat(d.Fields["t1"].Value, 64)
-}
-
 type DeviceResponse struct {
 	Response struct {
 		Data []Device `json:"data"`
@@ -73,8 +51,25 @@ type DeviceResponse struct {
 }
 
 func (client APIClient) GetDevice(deviceId string) (*Device, error) {
+	devices, err := client.GetDevices([]string{deviceId})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(devices) == 0 {
+		return nil, fmt.Errorf("device not found: %s", deviceId)
+	}
+
+	return &devices[0], nil
+}
+
+// GetDevices fetches the current state of several devices in one request.
+// Devices the API does not return are left out rather than treated as an
+// error, so callers should check which IDs came back.
+func (client APIClient) GetDevices(deviceIds []string) ([]Device, error) {
 	deviceRequest := DeviceRequest{
-		DeviceIds: []string{deviceId},
+		DeviceIds: deviceIds,
 	}
 
 	jsonData, err := json.Marshal(deviceRequest)
@@ -82,10 +77,10 @@ func (client APIClient) GetDevice(deviceId string) (*Device, error) {
 		return nil, fmt.Errorf("failed to marshal device request: %s", err)
 	}
 
-	body, err := client.MakeRequest("device2/device2-service/user/device", "POST", bytes.NewBuffer(jsonData))
+	body, err := client.makeIdempotentRequest("device2/device2-service/user/device", "POST", jsonData)
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to get device: %s", err)
+		return nil, fmt.Errorf("failed to get devices: %w", err)
 	}
 
 	var result DeviceResponse
@@ -93,9 +88,30 @@ func (client APIClient) GetDevice(deviceId string) (*Device, error) {
 		return nil, fmt.Errorf("failed to unmarshal device response: %s", err)
 	}
 
-	if len(result.Response.Data) == 0 {
-		return nil, fmt.Errorf("device not found: %s", deviceId)
+	if len(result.Response.Data) == 0 && result.Response.Code != "" {
+		return nil, fmt.Errorf("failed to get devices: %w", &ResponseCodeError{Code: result.Response.Code})
+	}
+
+	return result.Response.Data, nil
+}
+
+type DeviceUpdateRequest struct {
+	Payload map[string]string `json:"payload"`
+}
+
+// UpdateDeviceFields sets fields on a device, such as a pump's target speed.
+// Setting a field to a value is safe to repeat, so the request is retried like
+// a read. The response carries nothing useful; the device reports the new
+// values on its next poll.
+func (client APIClient) UpdateDeviceFields(deviceId string, fields map[string]string) error {
+	jsonData, err := json.Marshal(DeviceUpdateRequest{Payload: fields})
+	if err != nil {
+		return fmt.Errorf("failed to marshal device update request: %s", err)
+	}
+
+	if _, err := client.makeIdempotentRequest(fmt.Sprintf("device/device-service/user/device/%s", deviceId), "PUT", jsonData); err != nil {
+		return fmt.Errorf("failed to update device %s: %w", deviceId, err)
 	}
 
-	return &result.Response.Data[0], nil
+	return nil
 }
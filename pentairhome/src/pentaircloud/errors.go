@@ -0,0 +1,143 @@
+package pentaircloud
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UnauthorizedError is returned for a 401, meaning the tokens or credentials
+// used to sign the request were not accepted and a new login is needed.
+type UnauthorizedError struct {
+	Body string
+}
+
+func (e *UnauthorizedError) Error() string {
+	return fmt.Sprintf("unauthorized: %s", e.Body)
+}
+
+// ForbiddenError is returned for a 403. Pentair's API gateway also answers
+// with 403 when a SigV4 signature has expired, so it is treated as an
+// authentication failure as well.
+type ForbiddenError struct {
+	Body string
+}
+
+func (e *ForbiddenError) Error() string {
+	return fmt.Sprintf("forbidden: %s", e.Body)
+}
+
+// ThrottledError is returned for a 429. RetryAfter is zero when the response
+// did not say how long to wait.
+type ThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *ThrottledError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("throttled, retry after %s", e.RetryAfter)
+	}
+
+	return "throttled"
+}
+
+// ServerError is returned for any 5xx response.
+type ServerError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("server error %d: %s", e.StatusCode, e.Body)
+}
+
+// StatusError is returned for any other unsuccessful status. It usually means
+// the request itself is wrong.
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status %d: %s", e.StatusCode, e.Body)
+}
+
+// ResponseCodeError is returned when the API answers successfully at the HTTP
+// level but reports an error code in the response body.
+type ResponseCodeError struct {
+	Code string
+}
+
+func (e *ResponseCodeError) Error() string {
+	return fmt.Sprintf("api error code: %s", e.Code)
+}
+
+// IsAuthError reports whether err means the caller should re-authenticate.
+func IsAuthError(err error) bool {
+	var unauthorized *UnauthorizedError
+	var forbidden *ForbiddenError
+
+	return errors.As(err, &unauthorized) || errors.As(err, &forbidden)
+}
+
+// IsThrottled reports whether err means the caller should back off, and for
+// how long if the API said.
+func IsThrottled(err error) (time.Duration, bool) {
+	var throttled *ThrottledError
+
+	if errors.As(err, &throttled) {
+		return throttled.RetryAfter, true
+	}
+
+	return 0, false
+}
+
+// Only the start of an error body is kept; API gateway errors are short and
+// anything longer is usually an HTML error page.
+const maxErrorBodyLength = 256
+
+func checkResponse(resp *http.Response, body []byte, now time.Time) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	message := strings.TrimSpace(string(body))
+
+	if len(message) > maxErrorBodyLength {
+		message = message[:maxErrorBodyLength]
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		return &UnauthorizedError{Body: message}
+	case resp.StatusCode == http.StatusForbidden:
+		return &ForbiddenError{Body: message}
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return &ThrottledError{RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), now)}
+	case resp.StatusCode >= 500:
+		return &ServerError{StatusCode: resp.StatusCode, Body: message}
+	}
+
+	return &StatusError{StatusCode: resp.StatusCode, Body: message}
+}
+
+// parseRetryAfter reads a Retry-After header given either in seconds or as an
+// HTTP date.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+
+	return 0
+}
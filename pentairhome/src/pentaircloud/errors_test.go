@@ -0,0 +1,58 @@
+package pentaircloud
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCheckResponse(t *testing.T) {
+	now := time.Date(2026, time.June, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		status int
+		header string
+		check  func(error) bool
+	}{
+		{200, "", func(err error) bool { return err == nil }},
+		{401, "", func(err error) bool { return IsAuthError(err) }},
+		{403, "", func(err error) bool { return IsAuthError(err) }},
+		{429, "30", func(err error) bool {
+			retryAfter, throttled := IsThrottled(err)
+			return throttled && retryAfter == 30*time.Second
+		}},
+		{429, "Mon, 01 Jun 2026 12:02:00 GMT", func(err error) bool {
+			retryAfter, throttled := IsThrottled(err)
+			return throttled && retryAfter == 2*time.Minute
+		}},
+		{503, "", func(err error) bool {
+			var serverErr *ServerError
+			return errors.As(err, &serverErr) && serverErr.StatusCode == 503
+		}},
+		{404, "", func(err error) bool {
+			var statusErr *StatusError
+			return errors.As(err, &statusErr) && !IsAuthError(err)
+		}},
+	}
+
+	for _, test := range tests {
+		resp := &http.Response{StatusCode: test.status, Header: http.Header{}}
+
+		if test.header != "" {
+			resp.Header.Set("Retry-After", test.header)
+		}
+
+		err := checkResponse(resp, []byte("body"), now)
+
+		// Callers see the error wrapped with the request that failed
+		if err != nil {
+			err = fmt.Errorf("GET endpoint: %w", err)
+		}
+
+		if !test.check(err) {
+			t.Errorf("checkResponse(%d, %q) = %v", test.status, test.header, err)
+		}
+	}
+}
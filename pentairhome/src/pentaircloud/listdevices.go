@@ -16,10 +16,6 @@ type ListDevice struct {
 	ProductInfo ProductInfo `json:"productInfo"`
 }
 
-func (ld ListDevice) IsIntelliConnect() bool {
-	return ld.ProductInfo.Model == "IntelliConnect"
-}
-
 type ListDevicesResponse struct {
 	Response        []ListDevice `json:"response"`
 	AllDevicesCount int          `json:"allDevicesCount"`
@@ -27,10 +23,10 @@ type ListDevicesResponse struct {
 }
 
 func (client APIClient) ListDevices() ([]ListDevice, error) {
-	body, bodyErr := client.MakeRequest("device2/device2-service/user/listdevices", "GET", nil)
+	body, bodyErr := client.makeIdempotentRequest("device2/device2-service/user/listdevices", "GET", nil)
 
 	if bodyErr != nil {
-		return nil, fmt.Errorf("failed to list devices: %s", bodyErr)
+		return nil, fmt.Errorf("failed to list devices: %w", bodyErr)
 	}
 
 	var result ListDevicesResponse
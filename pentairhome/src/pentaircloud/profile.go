@@ -5,9 +5,13 @@ import (
 	"fmt"
 )
 
+// Profile is the signed in user's account. Units is the unit system chosen in
+// the Pentair Home app; its name has not been checked against the API, so it
+// may be empty.
 type Profile struct {
 	Code    string `json:"code"`
 	Message string `json:"message"`
+	Units   string `json:"units"`
 }
 
 type ProfileResponse struct {
@@ -16,10 +20,10 @@ type ProfileResponse struct {
 }
 
 func (client APIClient) GetProfile() (*Profile, error) {
-	body, bodyErr := client.MakeRequest("user/user-service/common/profile", "GET", nil)
+	body, bodyErr := client.makeIdempotentRequest("user/user-service/common/profile", "GET", nil)
 
 	if bodyErr != nil {
-		return nil, fmt.Errorf("failed to get profile: %s", bodyErr)
+		return nil, fmt.Errorf("failed to get profile: %w", bodyErr)
 	}
 
 	var result ProfileResponse
@@ -0,0 +1,94 @@
+package pentaircloud
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand/v2"
+	"net/url"
+	"time"
+)
+
+// RetryPolicy controls how idempotent requests are retried after transient
+// failures. Delays grow exponentially from BaseDelay up to MaxDelay and are
+// spread by up to Jitter (a fraction of the delay) either way.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	Jitter      float64
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 4,
+		BaseDelay:   time.Second,
+		MaxDelay:    30 * time.Second,
+		Jitter:      0.2,
+	}
+}
+
+// Do calls fn until it succeeds, fails with an error that retrying will not
+// fix, the attempts run out or ctx is cancelled.
+func (p RetryPolicy) Do(ctx context.Context, description string, fn func() ([]byte, error)) ([]byte, error) {
+	var authRetried bool
+
+	for attempt := 1; ; attempt++ {
+		body, err := fn()
+
+		if err == nil || attempt >= p.MaxAttempts || ctx.Err() != nil {
+			return body, err
+		}
+
+		delay := p.backoff(attempt, rand.Float64())
+
+		if retryAfter, throttled := IsThrottled(err); throttled {
+			if retryAfter > p.MaxDelay {
+				return nil, err
+			}
+
+			delay = max(delay, retryAfter)
+		} else if IsAuthError(err) {
+			// The failed request invalidated the credentials, so one retry is
+			// enough to find out whether fresh ones are accepted.
+			if authRetried {
+				return nil, err
+			}
+
+			authRetried = true
+			delay = 0
+		} else if !isTransient(err) {
+			return nil, err
+		}
+
+		log.Printf("Retrying %s in %s (attempt %d of %d): %s", description, delay.Round(time.Millisecond), attempt+1, p.MaxAttempts, err)
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// backoff returns the delay before the retry following attempt, with r a
+// random number in [0, 1) used to apply the jitter.
+func (p RetryPolicy) backoff(attempt int, r float64) time.Duration {
+	delay := p.BaseDelay
+
+	for i := 1; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	delay = min(delay, p.MaxDelay)
+	jitter := float64(delay) * p.Jitter * (2*r - 1)
+
+	return max(0, delay+time.Duration(jitter))
+}
+
+func isTransient(err error) bool {
+	var serverErr *ServerError
+	var urlErr *url.Error
+
+	return errors.As(err, &serverErr) || errors.As(err, &urlErr)
+}
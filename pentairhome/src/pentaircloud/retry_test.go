@@ -0,0 +1,125 @@
+package pentaircloud
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func testRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   time.Millisecond,
+		MaxDelay:    4 * time.Millisecond,
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{
+		BaseDelay: time.Second,
+		MaxDelay:  5 * time.Second,
+		Jitter:    0.5,
+	}
+
+	tests := []struct {
+		attempt  int
+		random   float64
+		expected time.Duration
+	}{
+		{1, 0.5, time.Second},
+		{2, 0.5, 2 * time.Second},
+		{3, 0.5, 4 * time.Second},
+		{4, 0.5, 5 * time.Second},
+		{40, 0.5, 5 * time.Second},
+		{1, 0, 500 * time.Millisecond},
+		{1, 1, 1500 * time.Millisecond},
+	}
+
+	for _, test := range tests {
+		if delay := policy.backoff(test.attempt, test.random); delay != test.expected {
+			t.Errorf("backoff(%d, %v) = %s, want %s", test.attempt, test.random, delay, test.expected)
+		}
+	}
+}
+
+func TestRetryPolicyRetriesTransientErrors(t *testing.T) {
+	calls := 0
+
+	body, err := testRetryPolicy().Do(context.Background(), "test", func() ([]byte, error) {
+		calls++
+
+		if calls < 3 {
+			return nil, &ServerError{StatusCode: 502}
+		}
+
+		return []byte("ok"), nil
+	})
+
+	if err != nil || string(body) != "ok" {
+		t.Errorf("Do() = %s, %v, want ok", body, err)
+	}
+
+	if calls != 3 {
+		t.Errorf("Do() made %d calls, want 3", calls)
+	}
+}
+
+func TestRetryPolicyStopsOnPermanentErrors(t *testing.T) {
+	calls := 0
+
+	_, err := testRetryPolicy().Do(context.Background(), "test", func() ([]byte, error) {
+		calls++
+		return nil, &StatusError{StatusCode: 400}
+	})
+
+	var statusErr *StatusError
+
+	if !errors.As(err, &statusErr) || calls != 1 {
+		t.Errorf("Do() = %v after %d calls, want StatusError after 1 call", err, calls)
+	}
+}
+
+func TestRetryPolicyRetriesAuthErrorsOnce(t *testing.T) {
+	calls := 0
+
+	_, err := testRetryPolicy().Do(context.Background(), "test", func() ([]byte, error) {
+		calls++
+		return nil, &UnauthorizedError{}
+	})
+
+	if !IsAuthError(err) || calls != 2 {
+		t.Errorf("Do() = %v after %d calls, want UnauthorizedError after 2 calls", err, calls)
+	}
+}
+
+func TestRetryPolicyGivesUpWhenRetryAfterTooLong(t *testing.T) {
+	calls := 0
+
+	_, err := testRetryPolicy().Do(context.Background(), "test", func() ([]byte, error) {
+		calls++
+		return nil, &ThrottledError{RetryAfter: time.Minute}
+	})
+
+	if _, throttled := IsThrottled(err); !throttled || calls != 1 {
+		t.Errorf("Do() = %v after %d calls, want ThrottledError after 1 call", err, calls)
+	}
+}
+
+func TestRetryPolicyHonoursCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	policy := testRetryPolicy()
+	policy.BaseDelay = time.Hour
+	policy.MaxDelay = time.Hour
+
+	_, err := policy.Do(ctx, "test", func() ([]byte, error) {
+		cancel()
+		return nil, &ServerError{StatusCode: 500}
+	})
+
+	var serverErr *ServerError
+
+	if !errors.As(err, &serverErr) {
+		t.Errorf("Do() = %v, want the ServerError from the cancelled attempt", err)
+	}
+}
@@ -0,0 +1,128 @@
+package pentaircloud
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// ScheduleProgram is one program of a pump's schedule: run at Speed rpm from
+// Start to End (HH:MM, local to the device) on the given Days, where 0 is
+// Sunday. A program whose End is before its Start runs past midnight.
+type ScheduleProgram struct {
+	ID      int    `json:"id"`
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+	Start   string `json:"startTime"`
+	End     string `json:"endTime"`
+	Speed   int    `json:"speed"`
+	Days    []int  `json:"days"`
+}
+
+type Schedule struct {
+	Programs []ScheduleProgram `json:"programs"`
+}
+
+type ScheduleResponse struct {
+	Response Schedule `json:"response"`
+	Code     string   `json:"code"`
+}
+
+// Program returns the program with the given ID.
+func (s *Schedule) Program(id int) (*ScheduleProgram, bool) {
+	for i := range s.Programs {
+		if s.Programs[i].ID == id {
+			return &s.Programs[i], true
+		}
+	}
+
+	return nil, false
+}
+
+// Validate checks every program before the schedule is sent to the device, as
+// the API accepts schedules the pump cannot run.
+func (s Schedule) Validate(minSpeed, maxSpeed int) error {
+	seen := map[int]bool{}
+
+	for _, program := range s.Programs {
+		if seen[program.ID] {
+			return fmt.Errorf("program %d appears more than once", program.ID)
+		}
+
+		seen[program.ID] = true
+
+		if err := program.Validate(minSpeed, maxSpeed); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p ScheduleProgram) Validate(minSpeed, maxSpeed int) error {
+	start, err := time.Parse("15:04", p.Start)
+
+	if err != nil {
+		return fmt.Errorf("program %d has an invalid start time %q", p.ID, p.Start)
+	}
+
+	end, err := time.Parse("15:04", p.End)
+
+	if err != nil {
+		return fmt.Errorf("program %d has an invalid end time %q", p.ID, p.End)
+	}
+
+	if start.Equal(end) {
+		return fmt.Errorf("program %d starts and ends at %s", p.ID, p.Start)
+	}
+
+	if p.Speed < minSpeed || p.Speed > maxSpeed {
+		return fmt.Errorf("program %d speed %d is outside %d to %d", p.ID, p.Speed, minSpeed, maxSpeed)
+	}
+
+	for _, day := range p.Days {
+		if day < 0 || day > 6 {
+			return fmt.Errorf("program %d has an invalid day %d", p.ID, day)
+		}
+	}
+
+	return nil
+}
+
+func scheduleEndpoint(deviceId string) string {
+	return fmt.Sprintf("device2/device2-service/user/device/%s/schedule", deviceId)
+}
+
+func (client APIClient) GetSchedule(deviceId string) (*Schedule, error) {
+	body, err := client.makeIdempotentRequest(scheduleEndpoint(deviceId), "GET", nil)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get schedule for %s: %w", deviceId, err)
+	}
+
+	var result ScheduleResponse
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal schedule response: %s", err)
+	}
+
+	if result.Response.Programs == nil && result.Code != "" {
+		return nil, fmt.Errorf("failed to get schedule for %s: %w", deviceId, &ResponseCodeError{Code: result.Code})
+	}
+
+	return &result.Response, nil
+}
+
+// UpdateSchedule replaces the device's schedule. Callers should Validate it
+// first. Like UpdateDeviceFields it sets absolute values, so it is retried.
+func (client APIClient) UpdateSchedule(deviceId string, schedule Schedule) error {
+	jsonData, err := json.Marshal(schedule)
+	if err != nil {
+		return fmt.Errorf("failed to marshal schedule: %s", err)
+	}
+
+	if _, err := client.makeIdempotentRequest(scheduleEndpoint(deviceId), "PUT", jsonData); err != nil {
+		return fmt.Errorf("failed to update schedule for %s: %w", deviceId, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,37 @@
+package pentaircloud
+
+import "testing"
+
+func TestScheduleValidate(t *testing.T) {
+	valid := ScheduleProgram{ID: 1, Start: "08:00", End: "12:30", Speed: 2000, Days: []int{1, 2, 3}}
+
+	tests := []struct {
+		name  string
+		edit  func(p *ScheduleProgram)
+		valid bool
+	}{
+		{"valid", func(p *ScheduleProgram) {}, true},
+		{"past midnight", func(p *ScheduleProgram) { p.Start, p.End = "22:00", "02:00" }, true},
+		{"bad start", func(p *ScheduleProgram) { p.Start = "25:00" }, false},
+		{"bad end", func(p *ScheduleProgram) { p.End = "noon" }, false},
+		{"empty range", func(p *ScheduleProgram) { p.End = p.Start }, false},
+		{"too slow", func(p *ScheduleProgram) { p.Speed = 300 }, false},
+		{"too fast", func(p *ScheduleProgram) { p.Speed = 3600 }, false},
+		{"bad day", func(p *ScheduleProgram) { p.Days = []int{7} }, false},
+	}
+
+	for _, test := range tests {
+		program := valid
+		test.edit(&program)
+
+		if err := (Schedule{Programs: []ScheduleProgram{program}}).Validate(450, 3450); (err == nil) != test.valid {
+			t.Errorf("%s: Validate() = %v, want valid %t", test.name, err, test.valid)
+		}
+	}
+
+	duplicate := Schedule{Programs: []ScheduleProgram{valid, valid}}
+
+	if err := duplicate.Validate(450, 3450); err == nil {
+		t.Error("expected duplicate program IDs to be rejected")
+	}
+}
@@ -0,0 +1,56 @@
+package persist
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestWriteJSONRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "session.json")
+	written := map[string]string{"refresh_token": "token"}
+
+	if err := WriteJSON(path, written); err != nil {
+		t.Fatalf("WriteJSON() error = %s", err)
+	}
+
+	var read map[string]string
+
+	if err := ReadJSON(path, &read); err != nil {
+		t.Fatalf("ReadJSON() error = %s", err)
+	}
+
+	if !reflect.DeepEqual(read, written) {
+		t.Errorf("ReadJSON() = %v, want %v", read, written)
+	}
+}
+
+func TestWriteJSONRestrictsPermissions(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "state")
+	path := filepath.Join(dir, "session.json")
+
+	if err := WriteJSON(path, "secret"); err != nil {
+		t.Fatalf("WriteJSON() error = %s", err)
+	}
+
+	fileInfo, err := os.Stat(path)
+
+	if err != nil {
+		t.Fatalf("Stat() error = %s", err)
+	}
+
+	if mode := fileInfo.Mode().Perm(); mode != 0o600 {
+		t.Errorf("file mode = %o, want 600", mode)
+	}
+
+	dirInfo, err := os.Stat(dir)
+
+	if err != nil {
+		t.Fatalf("Stat() error = %s", err)
+	}
+
+	if mode := dirInfo.Mode().Perm(); mode != 0o700 {
+		t.Errorf("directory mode = %o, want 700", mode)
+	}
+}
@@ -2,7 +2,9 @@ package sensor
 
 import (
 	"fmt"
+	"pentairhome/mqtt"
 	"pentairhome/pentaircloud"
+	"strconv"
 )
 
 type DiscoveryDevice struct {
@@ -12,32 +14,59 @@ type DiscoveryDevice struct {
 	SwVersion    string   `json:"sw_version"`
 }
 
+// Availability is a topic Home Assistant checks before showing an entity's
+// state. Every entity lists the add-on's and its device's, and is only
+// available when both are online.
+type Availability struct {
+	Topic string `json:"topic"`
+}
+
+func availability(topics mqtt.Topics, device *pentaircloud.Device) []Availability {
+	return append(BridgeAvailability(topics), Availability{Topic: DeviceAvailabilityTopic(topics, device)})
+}
+
+// BridgeAvailability is the availability of entities that stay available
+// while their device is offline, such as its connectivity.
+func BridgeAvailability(topics mqtt.Topics) []Availability {
+	return []Availability{{Topic: topics.Availability()}}
+}
+
+// DeviceAvailabilityTopic says whether the device is online.
+func DeviceAvailabilityTopic(topics mqtt.Topics, device *pentaircloud.Device) string {
+	return topics.Device(device.DeviceID, "availability")
+}
+
 type SensorConfig struct {
-	Name              string          `json:"name"`
-	StateTopic        string          `json:"state_topic"`
-	DeviceClass       string          `json:"device_class"`
-	ValueTemplate     string          `json:"value_template"`
-	UniqueID          string          `json:"unique_id"`
-	Device            DiscoveryDevice `json:"device"`
-	UnitOfMeasurement string          `json:"unit_of_measurement"`
+	Name                   string          `json:"name"`
+	StateTopic             string          `json:"state_topic"`
+	DeviceClass            string          `json:"device_class,omitempty"`
+	ValueTemplate          string          `json:"value_template"`
+	UniqueID               string          `json:"unique_id"`
+	Device                 DiscoveryDevice `json:"device"`
+	UnitOfMeasurement      string          `json:"unit_of_measurement,omitempty"`
+	EntityCategory         string          `json:"entity_category,omitempty"`
+	StateClass             string          `json:"state_class,omitempty"`
+	ExpireAfter            int             `json:"expire_after,omitempty"`
+	Availability           []Availability  `json:"availability"`
+	AvailabilityMode       string          `json:"availability_mode"`
+	JSONAttributesTopic    string          `json:"json_attributes_topic,omitempty"`
+	JSONAttributesTemplate string          `json:"json_attributes_template,omitempty"`
 }
 
-type SensorData struct {
-	Power       float64 `json:"power"`
-	ActualSpeed float64 `json:"actualspeed"`
-	ActualFlow  float64 `json:"actualflow"`
-	ActualTemp  float64 `json:"actualtemp"`
-	OutsideTemp float64 `json:"outsidetemp"`
+func UniqueID(device *pentaircloud.Device, sensorID string) string {
+	return fmt.Sprintf("ph_%s_%s", device.DeviceID, sensorID)
 }
 
-func GenerateSensorConfig(device *pentaircloud.Device, sensorName, sensorID, deviceClass, unitOfMeasurement string) SensorConfig {
+func GenerateSensorConfig(topics mqtt.Topics, device *pentaircloud.Device, sensorName, sensorID, deviceClass, unitOfMeasurement string) SensorConfig {
 	return SensorConfig{
 		Name:              sensorName,
-		UniqueID:          fmt.Sprintf("ph_%s_%s", device.DeviceID, sensorID),
-		StateTopic:        fmt.Sprintf("pentairhome/%s", device.DeviceID),
+		UniqueID:          UniqueID(device, sensorID),
+		StateTopic:        topics.Device(device.DeviceID),
 		DeviceClass:       deviceClass,
 		ValueTemplate:     fmt.Sprintf("{{ value_json.%s }}", sensorID),
 		UnitOfMeasurement: unitOfMeasurement,
+		Availability:      availability(topics, device),
+		AvailabilityMode:  "all",
 		Device: DiscoveryDevice{
 			Name:         device.ProductInfo.NickName,
 			Identifiers:  []string{device.DeviceID},
@@ -46,3 +75,181 @@ func GenerateSensorConfig(device *pentaircloud.Device, sensorName, sensorID, dev
 		},
 	}
 }
+
+// GenerateBinarySensorConfig is GenerateSensorConfig for a boolean value,
+// mapped to the ON/OFF payloads Home Assistant expects by default.
+func GenerateBinarySensorConfig(topics mqtt.Topics, device *pentaircloud.Device, sensorName, sensorID, deviceClass string) SensorConfig {
+	config := GenerateSensorConfig(topics, device, sensorName, sensorID, deviceClass, "")
+	config.ValueTemplate = fmt.Sprintf("{{ 'ON' if value_json.%s else 'OFF' }}", sensorID)
+
+	return config
+}
+
+// NumberConfig is the discovery config of a number entity, which Home
+// Assistant sets by publishing the new value to CommandTopic.
+type NumberConfig struct {
+	SensorConfig
+	CommandTopic string  `json:"command_topic"`
+	Min          float64 `json:"min"`
+	Max          float64 `json:"max"`
+	Step         float64 `json:"step,omitempty"`
+	Mode         string  `json:"mode"`
+}
+
+// CommandTopic is where Home Assistant sends commands for an entity. command
+// is empty for the entity's own value, or names another setting of an entity
+// that has several.
+func CommandTopic(topics mqtt.Topics, device *pentaircloud.Device, sensorID, command string) string {
+	if command == "" {
+		return topics.Device(device.DeviceID, sensorID, "set")
+	}
+
+	return topics.Device(device.DeviceID, sensorID, command, "set")
+}
+
+func GenerateNumberConfig(topics mqtt.Topics, device *pentaircloud.Device, sensorName, sensorID, unitOfMeasurement string, min, max, step float64) NumberConfig {
+	return NumberConfig{
+		SensorConfig: GenerateSensorConfig(topics, device, sensorName, sensorID, "", unitOfMeasurement),
+		CommandTopic: CommandTopic(topics, device, sensorID, ""),
+		Min:          min,
+		Max:          max,
+		Step:         step,
+		Mode:         "box",
+	}
+}
+
+// ClimateConfig is the discovery config of a climate entity. Its state comes
+// from the device's state topic, with the current temperature, set point, mode
+// and preset read from one JSON object.
+type ClimateConfig struct {
+	Name                       string          `json:"name"`
+	UniqueID                   string          `json:"unique_id"`
+	Device                     DiscoveryDevice `json:"device"`
+	CurrentTemperatureTopic    string          `json:"current_temperature_topic"`
+	CurrentTemperatureTemplate string          `json:"current_temperature_template"`
+	TemperatureStateTopic      string          `json:"temperature_state_topic"`
+	TemperatureStateTemplate   string          `json:"temperature_state_template"`
+	TemperatureCommandTopic    string          `json:"temperature_command_topic"`
+	ModeStateTopic             string          `json:"mode_state_topic"`
+	ModeStateTemplate          string          `json:"mode_state_template"`
+	ModeCommandTopic           string          `json:"mode_command_topic"`
+	Modes                      []string        `json:"modes"`
+	PresetModeStateTopic       string          `json:"preset_mode_state_topic,omitempty"`
+	PresetModeValueTemplate    string          `json:"preset_mode_value_template,omitempty"`
+	PresetModeCommandTopic     string          `json:"preset_mode_command_topic,omitempty"`
+	PresetModes                []string        `json:"preset_modes,omitempty"`
+	MinTemp                    float64         `json:"min_temp"`
+	MaxTemp                    float64         `json:"max_temp"`
+	TempStep                   float64         `json:"temp_step,omitempty"`
+	TemperatureUnit            string          `json:"temperature_unit"`
+	Availability               []Availability  `json:"availability"`
+	AvailabilityMode           string          `json:"availability_mode"`
+}
+
+func GenerateClimateConfig(topics mqtt.Topics, device *pentaircloud.Device, climateName, climateID, temperatureUnit string, presets []string, min, max, step float64) ClimateConfig {
+	base := GenerateSensorConfig(topics, device, climateName, climateID, "", "")
+
+	config := ClimateConfig{
+		Name:                       climateName,
+		UniqueID:                   base.UniqueID,
+		Device:                     base.Device,
+		CurrentTemperatureTopic:    base.StateTopic,
+		CurrentTemperatureTemplate: fmt.Sprintf("{{ value_json.%s.current }}", climateID),
+		TemperatureStateTopic:      base.StateTopic,
+		TemperatureStateTemplate:   fmt.Sprintf("{{ value_json.%s.setpoint }}", climateID),
+		TemperatureCommandTopic:    CommandTopic(topics, device, climateID, ""),
+		ModeStateTopic:             base.StateTopic,
+		ModeStateTemplate:          fmt.Sprintf("{{ value_json.%s.mode }}", climateID),
+		ModeCommandTopic:           CommandTopic(topics, device, climateID, "mode"),
+		Modes:                      []string{"off", "heat"},
+		MinTemp:                    min,
+		MaxTemp:                    max,
+		TempStep:                   step,
+		TemperatureUnit:            temperatureUnit,
+		Availability:               base.Availability,
+		AvailabilityMode:           base.AvailabilityMode,
+	}
+
+	if len(presets) > 1 {
+		config.PresetModeStateTopic = base.StateTopic
+		config.PresetModeValueTemplate = fmt.Sprintf("{{ value_json.%s.preset }}", climateID)
+		config.PresetModeCommandTopic = CommandTopic(topics, device, climateID, "preset")
+		config.PresetModes = presets
+	}
+
+	return config
+}
+
+// SwitchConfig is the discovery config of a switch. It is not optimistic, so
+// Home Assistant shows the state the device last reported rather than assuming
+// a command worked.
+type SwitchConfig struct {
+	SensorConfig
+	CommandTopic string `json:"command_topic"`
+	Optimistic   bool   `json:"optimistic"`
+}
+
+func GenerateSwitchConfig(topics mqtt.Topics, device *pentaircloud.Device, switchName, switchID string) SwitchConfig {
+	return SwitchConfig{
+		SensorConfig: GenerateBinarySensorConfig(topics, device, switchName, switchID, ""),
+		CommandTopic: CommandTopic(topics, device, switchID, ""),
+		Optimistic:   false,
+	}
+}
+
+// LightConfig is the discovery config of an on/off light, which like a switch
+// is not optimistic.
+type LightConfig struct {
+	Name               string          `json:"name"`
+	UniqueID           string          `json:"unique_id"`
+	Device             DiscoveryDevice `json:"device"`
+	StateTopic         string          `json:"state_topic"`
+	StateValueTemplate string          `json:"state_value_template"`
+	CommandTopic       string          `json:"command_topic"`
+	Optimistic         bool            `json:"optimistic"`
+	Availability       []Availability  `json:"availability"`
+	AvailabilityMode   string          `json:"availability_mode"`
+}
+
+func GenerateLightConfig(topics mqtt.Topics, device *pentaircloud.Device, lightName, lightID string) LightConfig {
+	base := GenerateBinarySensorConfig(topics, device, lightName, lightID, "")
+
+	return LightConfig{
+		Name:               lightName,
+		UniqueID:           base.UniqueID,
+		Device:             base.Device,
+		StateTopic:         base.StateTopic,
+		StateValueTemplate: base.ValueTemplate,
+		CommandTopic:       CommandTopic(topics, device, lightID, ""),
+		Optimistic:         false,
+		Availability:       base.Availability,
+		AvailabilityMode:   base.AvailabilityMode,
+	}
+}
+
+// ScheduleTopic carries a device's schedule programs, keyed by program ID.
+func ScheduleTopic(topics mqtt.Topics, device *pentaircloud.Device) string {
+	return topics.Device(device.DeviceID, "schedule")
+}
+
+// GenerateProgramBinarySensorConfig is the binary sensor showing whether one
+// schedule program is enabled, with the program's details as its attributes.
+func GenerateProgramBinarySensorConfig(topics mqtt.Topics, device *pentaircloud.Device, programName string, programID int) SensorConfig {
+	config := GenerateBinarySensorConfig(topics, device, programName, fmt.Sprintf("program%d", programID), "")
+	config.StateTopic = ScheduleTopic(topics, device)
+	config.ValueTemplate = fmt.Sprintf("{{ 'ON' if value_json['%d'].enabled else 'OFF' }}", programID)
+	config.JSONAttributesTopic = ScheduleTopic(topics, device)
+	config.JSONAttributesTemplate = fmt.Sprintf("{{ value_json['%d'] | tojson }}", programID)
+
+	return config
+}
+
+// GenerateProgramSwitchConfig is GenerateProgramBinarySensorConfig as a
+// switch that enables or disables the program.
+func GenerateProgramSwitchConfig(topics mqtt.Topics, device *pentaircloud.Device, programName string, programID int) SwitchConfig {
+	return SwitchConfig{
+		SensorConfig: GenerateProgramBinarySensorConfig(topics, device, programName, programID),
+		CommandTopic: CommandTopic(topics, device, "schedule", strconv.Itoa(programID)),
+		Optimistic:   false,
+	}
+}
@@ -0,0 +1,138 @@
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Worker is a long running task. Returning nil means the worker has finished
+// and is not restarted; returning an error restarts it after a backoff unless
+// the Supervisor's Fatal says it cannot be fixed by restarting.
+type Worker func(ctx context.Context) error
+
+// Supervisor runs named workers until its context is cancelled, restarting
+// any that fail. Restarts back off exponentially from MinBackoff to
+// MaxBackoff, and the backoff resets once a worker has stayed up for
+// MaxBackoff.
+type Supervisor struct {
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Fatal reports errors that restarting cannot fix. A worker failing with
+	// one stops every worker.
+	Fatal func(err error) bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu  sync.Mutex
+	err error
+}
+
+func New(ctx context.Context) *Supervisor {
+	ctx, cancel := context.WithCancel(ctx)
+
+	return &Supervisor{
+		MinBackoff: time.Second,
+		MaxBackoff: 5 * time.Minute,
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+}
+
+// Go starts worker in its own goroutine under the given name.
+func (s *Supervisor) Go(name string, worker Worker) {
+	s.wg.Add(1)
+
+	go func() {
+		defer s.wg.Done()
+		s.supervise(name, worker)
+	}()
+}
+
+// Wait blocks until every worker has stopped.
+func (s *Supervisor) Wait() {
+	s.wg.Wait()
+}
+
+// Done is closed once the workers are stopping, either because the context
+// passed to New was cancelled or because one failed with a fatal error.
+func (s *Supervisor) Done() <-chan struct{} {
+	return s.ctx.Done()
+}
+
+// Err returns the fatal error that stopped the workers, if there was one.
+func (s *Supervisor) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.err
+}
+
+func (s *Supervisor) supervise(name string, worker Worker) {
+	backoff := s.MinBackoff
+	restarts := 0
+
+	for {
+		started := time.Now()
+		err := run(s.ctx, worker)
+
+		if s.ctx.Err() != nil {
+			log.Printf("Stopped %s", name)
+			return
+		}
+
+		if err == nil {
+			log.Printf("%s finished", name)
+			return
+		}
+
+		if s.Fatal != nil && s.Fatal(err) {
+			log.Printf("%s failed and will not be restarted: %s", name, err)
+			s.stop(err)
+			return
+		}
+
+		if time.Since(started) >= s.MaxBackoff {
+			backoff = s.MinBackoff
+		}
+
+		restarts++
+		log.Printf("%s failed, restart %d in %s: %s", name, restarts, backoff, err)
+
+		select {
+		case <-s.ctx.Done():
+			log.Printf("Stopped %s", name)
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff = min(backoff*2, s.MaxBackoff)
+	}
+}
+
+func (s *Supervisor) stop(err error) {
+	s.mu.Lock()
+
+	if s.err == nil {
+		s.err = err
+	}
+
+	s.mu.Unlock()
+	s.cancel()
+}
+
+// run calls worker, turning a panic into an error so one bad poll cannot take
+// the whole add-on down.
+func run(ctx context.Context, worker Worker) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	return worker(ctx)
+}
@@ -0,0 +1,101 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func testSupervisor(ctx context.Context) *Supervisor {
+	s := New(ctx)
+	s.MinBackoff = time.Millisecond
+	s.MaxBackoff = 4 * time.Millisecond
+
+	return s
+}
+
+func TestSupervisorRestartsFailedWorkers(t *testing.T) {
+	s := testSupervisor(context.Background())
+	calls := 0
+
+	s.Go("worker", func(ctx context.Context) error {
+		calls++
+
+		switch calls {
+		case 1:
+			return errors.New("failed")
+		case 2:
+			panic("panicked")
+		}
+
+		return nil
+	})
+
+	s.Wait()
+
+	if calls != 3 {
+		t.Errorf("worker ran %d times, want 3", calls)
+	}
+}
+
+func TestSupervisorStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := testSupervisor(ctx)
+	s.MinBackoff = time.Hour
+	s.MaxBackoff = time.Hour
+
+	started := make(chan struct{})
+
+	s.Go("worker", func(ctx context.Context) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	<-started
+	cancel()
+
+	done := make(chan struct{})
+
+	go func() {
+		s.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Wait() did not return after cancel")
+	}
+}
+
+func TestSupervisorStopsOnFatalError(t *testing.T) {
+	s := testSupervisor(context.Background())
+	fatal := errors.New("fatal")
+	s.Fatal = func(err error) bool {
+		return errors.Is(err, fatal)
+	}
+
+	calls := 0
+
+	s.Go("failing worker", func(ctx context.Context) error {
+		calls++
+		return fatal
+	})
+
+	s.Go("other worker", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	s.Wait()
+
+	if calls != 1 {
+		t.Errorf("worker ran %d times, want 1", calls)
+	}
+
+	if !errors.Is(s.Err(), fatal) {
+		t.Errorf("Err() = %v, want %v", s.Err(), fatal)
+	}
+}